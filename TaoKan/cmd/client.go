@@ -4,12 +4,15 @@ import (
 	"TaoKan/commander"
 	KubernetesAPI "TaoKan/k8s"
 	"bufio"
+	"encoding/json"
 	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"os"
 	"strings"
 	"time"
@@ -23,6 +26,7 @@ type backupList struct {
 
 var RemoteCluster string
 var RemotePort uint
+var RemoteNamespace string
 
 // clientCmd represents the client command
 var clientCmd = &cobra.Command{
@@ -57,7 +61,12 @@ var rsyncCmd = &cobra.Command{
 			log.Warnf("[Warning] Pvc %s is used by Pod %s", pvcName, usedByPods[0].Name)
 		}
 
-		transferPvcData(cmd, []v1.PersistentVolumeClaim{*pvc})
+		c, err := startCommander(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		transferPvcData(cmd, c, []v1.PersistentVolumeClaim{*pvc})
 	},
 }
 
@@ -141,7 +150,24 @@ func init() {
 	// clientCmd.PersistentFlags().String("foo", "", "A help for foo")
 	clientCmd.PersistentFlags().StringVarP(&RemoteCluster, "remote", "r", "", "Remote cluster domain")
 	clientCmd.PersistentFlags().UintVarP(&RemotePort, "port", "p", 2022, "Remote cluster port")
+	clientCmd.PersistentFlags().StringVar(&RemoteNamespace, "remote-namespace", "", "Target namespace in the remote cluster, the namespace of the server if empty")
 	clientCmd.MarkPersistentFlagRequired("remote")
+	clientCmd.PersistentFlags().String("known-hosts", "", "Path of the known_hosts file to verify the server host key")
+	clientCmd.PersistentFlags().String("known-hosts-configmap", commander.DefaultKnownHostsConfigMap, "ConfigMap to record the server host key on first use")
+	clientCmd.PersistentFlags().String("host-key-fingerprint", "", "Pinned SHA256 fingerprint of the server host key")
+	clientCmd.PersistentFlags().Bool("insecure-ignore-host-key", false, "Skip the server host key verification")
+	clientCmd.PersistentFlags().Duration("keepalive-interval", commander.DefaultKeepAliveInterval, "Interval of the keepalive messages to the server")
+	clientCmd.PersistentFlags().String("identity", "", "Path of the private key to authenticate to the server, besides the keys of the ssh agent")
+	clientCmd.PersistentFlags().String("certificate", "", "Path of the certificate of the identity, <identity>-cert.pub or "+commander.CertificateSecretKey+" next to it if exists")
+	clientCmd.PersistentFlags().String("identity-secret", "", "Secret which contains the private key ("+commander.IdentitySecretKey+") and the optional certificate ("+commander.CertificateSecretKey+")")
+	clientCmd.PersistentFlags().Duration("connect-timeout", 0, "Timeout of connecting to the server, the default of goph if 0")
+	clientCmd.PersistentFlags().Duration("action-timeout", commander.DefaultClientActionTimeout, "Deadline of each action on the client, no deadline if 0")
+	clientCmd.PersistentFlags().StringToString("action-timeouts", nil, "Deadline of the specified actions on the client, e.g. mount=10m,verify=1h")
+	clientCmd.PersistentFlags().String("transport", string(commander.SSHTransport), "Transport of the commander actions: ssh or https")
+	clientCmd.PersistentFlags().String("server-ca", "", "Path of the CA certificate to verify the https server, the system roots if empty")
+	clientCmd.PersistentFlags().String("tls-cert", "", "Path of the client certificate for the https transport")
+	clientCmd.PersistentFlags().String("tls-key", "", "Path of the client certificate key for the https transport")
+	clientCmd.PersistentFlags().String("api-token-file", "", "Path of the file containing the bearer token for the https transport")
 
 	clientCmd.PersistentFlags().String("user-list", "", "User whitelist")
 	clientCmd.PersistentFlags().String("user-exclusive-list", "", "User exclusion list")
@@ -154,6 +180,7 @@ func init() {
 	clientCmd.PersistentFlags().Int("worker-retry", 0, "Rsync-worker worker retry time")
 
 	clientCmd.Flags().Bool("daemon", false, "Enable daemon mode")
+	clientCmd.Flags().Bool("retry-skipped", false, "Retry the pvcs recorded as skipped for the quota of the server")
 	clientCmd.Flags().Bool("disable-user", false, "Disable backup user")
 	clientCmd.Flags().Bool("disable-project", false, "Disable backup project")
 	clientCmd.Flags().Bool("disable-dataset", false, "Disable backup dataset")
@@ -207,15 +234,27 @@ func clientEntrypoint(cmd *cobra.Command, args []string) {
 }
 
 func transferBackupData(cmd *cobra.Command, backupList backupList) {
+	c, err := startCommander(cmd)
+	if err != nil {
+		log.Errorf("[Abort] Connect to server err: %v", err)
+		return
+	}
+	defer c.Close()
+
+	err = checkCapabilities(c, requiredActions...)
+	if err != nil {
+		log.Errorf("[Abort] %v", err)
+		return
+	}
 
 	log.Infof("[Process] Project data transfer")
-	projectSucceed := transferPvcData(cmd, backupList.projectPvcs)
+	projectSucceed := transferPvcData(cmd, c, backupList.projectPvcs)
 
 	log.Infof("[Process] Dataset data transfer")
-	datasetSucceed := transferPvcData(cmd, backupList.datasetPvcs)
+	datasetSucceed := transferPvcData(cmd, c, backupList.datasetPvcs)
 
 	log.Infof("[Process] User data transfer")
-	userSucceed := transferPvcData(cmd, backupList.userPvcs)
+	userSucceed := transferPvcData(cmd, c, backupList.userPvcs)
 
 	log.Infof("[Summary]")
 	userTotal := len(backupList.userPvcs)
@@ -232,11 +271,106 @@ func transferBackupData(cmd *cobra.Command, backupList backupList) {
 	log.Infof("[Completed] transfer backup data ")
 }
 
-func transferPvcData(cmd *cobra.Command, pvcs []v1.PersistentVolumeClaim) int {
+func transferPvcData(cmd *cobra.Command, c *commander.Commander, pvcs []v1.PersistentVolumeClaim) int {
+	completedCount, retryPvcs := transferPvcs(cmd, c, pvcs)
+	if len(retryPvcs) > 0 {
+		log.Infof("[Retry] %d pvcs failed with retryable errors", len(retryPvcs))
+		log.Infof("[Retry] Cool down %d seconds", 60)
+		time.Sleep(60 * time.Second)
+		retriedCount, _ := transferPvcs(cmd, c, retryPvcs)
+		completedCount += retriedCount
+	}
+	return completedCount
+}
+
+// skipPvc logs the failed remote action and reports whether the pvc is worth to retry later
+func skipPvc(pvc v1.PersistentVolumeClaim, action string, err error) bool {
+	if commander.IsRetryable(err) {
+		log.Warnf("[Retry Later] %s Pvc %s err: %v", action, pvc.Name, err)
+		return true
+	}
+	log.Errorf("[Skip] %s Pvc %s err: %v", action, pvc.Name, err)
+	if commander.ErrorCodeOf(err) == commander.ErrCodeQuotaExceeded {
+		recordPermanentSkip(pvc, err)
+	}
+	return false
+}
+
+// SkippedPvcsConfigMap records the pvcs rejected by the quota of the server, which are skipped
+// until their capacity changes
+const SkippedPvcsConfigMap = "taokan-skipped-pvcs"
+
+type skipRecord struct {
+	Capacity  string              `json:"capacity"`
+	Code      commander.ErrorCode `json:"code"`
+	Message   string              `json:"message"`
+	Timestamp time.Time           `json:"timestamp"`
+}
+
+func loadSkipRecords() map[string]skipRecord {
+	records := map[string]skipRecord{}
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	configMap, err := k8s.GetConfigMap(Namespace, SkippedPvcsConfigMap)
+	if err != nil {
+		if !k8sErrors.IsNotFound(err) {
+			log.Warnf("[Skip] Load the skipped pvcs err: %v", err)
+		}
+		return records
+	}
+	for name, value := range configMap.Data {
+		var record skipRecord
+		if err := json.Unmarshal([]byte(value), &record); err != nil {
+			log.Warnf("[Skip] Invalid record of pvc %s: %v", name, err)
+			continue
+		}
+		records[name] = record
+	}
+	return records
+}
+
+func recordPermanentSkip(pvc v1.PersistentVolumeClaim, err error) {
+	record := skipRecord{
+		Capacity:  pvc.Spec.Resources.Requests.Storage().String(),
+		Code:      commander.ErrorCodeOf(err),
+		Message:   err.Error(),
+		Timestamp: time.Now().UTC(),
+	}
+	data, _ := json.Marshal(record)
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	err = k8s.ApplyConfigMap(Namespace, SkippedPvcsConfigMap, map[string]string{pvc.Name: string(data)})
+	if err != nil {
+		log.Warnf("[Skip] Record pvc %s err: %v", pvc.Name, err)
+		return
+	}
+	log.Warnf("[Skip] Pvc %s is skipped until its capacity changes, recorded in config map %s", pvc.Name, SkippedPvcsConfigMap)
+}
+
+// permanentlySkipped reports whether the pvc was rejected with the same capacity before
+func permanentlySkipped(cmd *cobra.Command, records map[string]skipRecord, pvc v1.PersistentVolumeClaim) bool {
+	record, ok := records[pvc.Name]
+	if !ok {
+		return false
+	}
+	if retry, _ := cmd.Flags().GetBool("retry-skipped"); retry {
+		return false
+	}
+	if record.Capacity != pvc.Spec.Resources.Requests.Storage().String() {
+		return false
+	}
+	log.Warnf("[Skip] Pvc %s was rejected at %s: %s", pvc.Name, record.Timestamp.Format(time.RFC3339), record.Message)
+	return true
+}
+
+func transferPvcs(cmd *cobra.Command, c *commander.Commander, pvcs []v1.PersistentVolumeClaim) (int, []v1.PersistentVolumeClaim) {
 	count := len(pvcs)
 	completedCount := 0
+	var retryPvcs []v1.PersistentVolumeClaim
+	skipRecords := loadSkipRecords()
 	for i, pvc := range pvcs {
 		log.Infof("[Backup] (%d/%d) Pvc: %s", i+1, count, pvc.Name)
+		if permanentlySkipped(cmd, skipRecords, pvc) {
+			continue
+		}
 		k8s := KubernetesAPI.GetInstance(KubeConfig)
 		if pvc.Spec.AccessModes[0] == v1.ReadWriteOnce {
 			usedPods, err := k8s.ListPodsUsePvc(Namespace, pvc.Name)
@@ -258,32 +392,48 @@ func transferPvcData(cmd *cobra.Command, pvcs []v1.PersistentVolumeClaim) int {
 			}
 		}
 
+		// Check the existing PVC in remote cluster
+		err := checkRemotePvc(c, pvc)
+		if err != nil {
+			if skipPvc(pvc, "Stat", err) {
+				retryPvcs = append(retryPvcs, pvc)
+			}
+			continue
+		}
+
 		// Ask remote cluster to touch PVC by rsyncServer pod
 		log.Infof("[Touch] Pvc %s in remote cluster", pvc.Name)
-		err := touchRemotePvc(cmd, pvc)
+		err = touchRemotePvc(c, pvc)
 		if err != nil {
-			log.Warnf("[Skip] pvc %s : %v", pvc.Name, err)
+			if skipPvc(pvc, "Touch", err) {
+				retryPvcs = append(retryPvcs, pvc)
+			}
 			continue
 		}
+		if _, ok := skipRecords[pvc.Name]; ok {
+			err = k8s.RemoveConfigMapKeys(Namespace, SkippedPvcsConfigMap, pvc.Name)
+			if err != nil {
+				log.Warnf("[Skip] Remove the record of pvc %s err: %v", pvc.Name, err)
+			}
+		}
 
 		// Ask remote cluster to mount PVC by rsync-server pod
 		log.Infof("[Mount] Pvc %s in remote cluster", pvc.Name)
-		outputLogs, err := commanderWrapper(cmd, "mount", pvc.Name)
+		response, err := commanderWrapper(c, "mount", commander.Args{"pvc": pvc.Name})
 		if err != nil {
-			log.Errorf("[Skip] Mount Pvc %s err: %v", pvc.Name, err)
+			if skipPvc(pvc, "Mount", err) {
+				retryPvcs = append(retryPvcs, pvc)
+			}
 			continue
 		}
-		isRsyncServerReady := false
-		for _, d := range outputLogs {
-			if d != "" {
-				log.Debugf(d)
-				if strings.Contains(d, "Server pod ready:") {
-					isRsyncServerReady = true
-				}
-			}
+		var mountResult commander.MountResult
+		err = response.Decode(&mountResult)
+		if err != nil {
+			log.Errorf("[Skip] Mount Pvc %s err: %v", pvc.Name, err)
+			continue
 		}
 
-		if !isRsyncServerReady {
+		if !mountResult.Ready {
 			log.Errorf("[Skip] Pvc %s due to rsync-server not running", pvc.Name)
 			continue
 		}
@@ -297,7 +447,7 @@ func transferPvcData(cmd *cobra.Command, pvcs []v1.PersistentVolumeClaim) int {
 				log.Infof("[Retry] Cool down %d seconds", 60)
 				time.Sleep(60 * time.Second)
 			}
-			err = k8s.LaunchRsyncWorkerPod(RemoteCluster, Namespace, pvc.Name, podRetryTimes)
+			err = k8s.LaunchRsyncWorkerPod(RemoteCluster, Namespace, RemoteNamespace, pvc.Name, podRetryTimes)
 			if err != nil {
 				log.Errorf("[Failed] Launch worker %v :%v", "rsync-worker-"+pvc.Name, err)
 				continue
@@ -309,25 +459,85 @@ func transferPvcData(cmd *cobra.Command, pvcs []v1.PersistentVolumeClaim) int {
 		}
 
 		log.Infof("[Unmount] Pvc %s in remote cluster", pvc.Name)
-		outputLogs, err = commanderWrapper(cmd, "umount", pvc.Name)
+		_, err = commanderWrapper(c, "umount", commander.Args{"pvc": pvc.Name})
 		if err != nil {
 			log.Errorf("[Skip] Unmount Pvc %s err: %v", pvc.Name, err)
 			continue
 		}
-		for _, d := range outputLogs {
-			if d != "" {
-				log.Debugf(d)
-			}
+	}
+	return completedCount, retryPvcs
+}
+
+// requiredActions are the actions the server must support to transfer the data
+var requiredActions = []string{"touch", "mount", "umount"}
+
+// checkCapabilities refuses the server which lacks the required actions
+func checkCapabilities(c *commander.Commander, actions ...string) error {
+	capabilities, err := c.Capabilities()
+	if err != nil {
+		return err
+	}
+	for _, action := range actions {
+		if !capabilities.SupportsAction(action) {
+			return fmt.Errorf("server %s does not support the action '%s'", capabilities.Version, action)
 		}
 	}
-	return completedCount
+	if RemoteNamespace != "" && !capabilities.SupportsFeature(commander.FeatureNamespace) {
+		return fmt.Errorf("server %s does not support the remote namespace", capabilities.Version)
+	}
+	return nil
+}
+
+// checkRemotePvc compares the source PVC with the one in remote cluster, returns error if it should not be transferred
+func checkRemotePvc(c *commander.Commander, pvc v1.PersistentVolumeClaim) error {
+	if capabilities, err := c.Capabilities(); err == nil && !capabilities.SupportsAction("stat") {
+		log.Debugf("[Stat] Not supported by server %s", capabilities.Version)
+		return nil
+	}
+	response, err := commanderWrapper(c, "stat", commander.Args{"pvc": pvc.Name})
+	switch commander.ErrorCodeOf(err) {
+	case commander.ErrCodePvcNotFound:
+		log.Infof("[Stat] Pvc %s not found in remote cluster", pvc.Name)
+		return nil
+	case commander.ErrCodeUnsupportedCommand:
+		log.Debugf("[Stat] Not supported by remote cluster")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var stat commander.PvcStat
+	err = response.Decode(&stat)
+	if err != nil {
+		return err
+	}
+	log.Infof("[Stat] Remote pvc %s phase: %s capacity: %s storage class: %s", stat.Name, stat.Phase, stat.Capacity, stat.StorageClass)
+
+	if stat.Phase == string(v1.ClaimLost) {
+		return fmt.Errorf("remote pvc %s is lost", stat.Name)
+	}
+	if len(stat.AccessModes) > 0 && len(pvc.Spec.AccessModes) > 0 && stat.AccessModes[0] != string(pvc.Spec.AccessModes[0]) {
+		log.Warnf("[Mismatch] Pvc %s access mode: %s, remote: %s", pvc.Name, pvc.Spec.AccessModes[0], stat.AccessModes[0])
+	}
+	if remoteCapacity, err := resource.ParseQuantity(stat.RequestedCapacity); err == nil {
+		if remoteCapacity.Cmp(*pvc.Spec.Resources.Requests.Storage()) < 0 {
+			log.Infof("[Expand] Pvc %s remote capacity: %s, requested: %s", pvc.Name, stat.RequestedCapacity, pvc.Spec.Resources.Requests.Storage())
+		}
+	}
+	for _, pod := range stat.UsedBy {
+		if pod != stat.RsyncServer {
+			return fmt.Errorf("remote pvc %s is used by pod %s", stat.Name, pod)
+		}
+	}
+	return nil
 }
 
-func touchRemotePvc(cmd *cobra.Command, pvc v1.PersistentVolumeClaim) error {
+func touchRemotePvc(c *commander.Commander, pvc v1.PersistentVolumeClaim) error {
 	var pvcType string
 	var name string
 	var capacity string
-	var accessMode string
+	args := commander.Args{}
 
 	if userName, ok := pvc.Annotations["hub.jupyter.org/username"]; ok {
 		pvcType = "user"
@@ -343,57 +553,20 @@ func touchRemotePvc(cmd *cobra.Command, pvc v1.PersistentVolumeClaim) error {
 	} else {
 		pvcType = "raw"
 		name = pvc.Name
-		accessMode = string(pvc.Spec.AccessModes[0])
+		args["accessMode"] = string(pvc.Spec.AccessModes[0])
 	}
 	capacity = pvc.Spec.Resources.Requests.Storage().String()
 
-	outputLogs, err := commanderWrapper(cmd, "touch", pvcType, name, capacity, accessMode)
+	capabilities, err := c.Capabilities()
 	if err != nil {
 		return err
 	}
-	for _, d := range outputLogs {
-		if d != "" {
-			log.Debugf(d)
-		}
-	}
-	return nil
-}
-
-func showAvaliblePvcs(namespace string) {
-	var content string
-	k8s := KubernetesAPI.GetInstance(KubeConfig)
-	userPvcs, err := k8s.ListUserPvc(Namespace)
-	if err != nil {
-		log.WithError(err)
-		os.Exit(1)
-	}
-	log.Infoln("[User] PVC")
-	content, err = k8s.ShowPvcStatus(Namespace, userPvcs)
-	for _, data := range strings.Split(content, "\n") {
-		log.Infof(data)
-	}
-
-	log.Infoln("[Dataset] PVC")
-	datasetPvcs, err := k8s.ListDatasetPvc(Namespace)
-	if err != nil {
-		log.WithError(err)
-		os.Exit(1)
-	}
-	content, err = k8s.ShowPvcStatus(Namespace, datasetPvcs)
-	for _, data := range strings.Split(content, "\n") {
-		log.Infof(data)
-	}
-
-	log.Infoln("[Project] PVC")
-	projectPvcs, err := k8s.ListProjectPvc(Namespace)
-	if err != nil {
-		log.WithError(err)
-		os.Exit(1)
-	}
-	content, err = k8s.ShowPvcStatus(Namespace, projectPvcs)
-	for _, data := range strings.Split(content, "\n") {
-		log.Infof(data)
+	if !capabilities.SupportsPvcType(pvcType) {
+		return fmt.Errorf("server %s does not support the %s pvc type", capabilities.Version, pvcType)
 	}
+	args["type"], args["name"], args["capacity"] = pvcType, name, capacity
+	_, err = commanderWrapper(c, "touch", args)
+	return err
 }
 
 func openListFile(path string) ([]string, error) {
@@ -590,33 +763,69 @@ func whiteListFactory(cmd *cobra.Command, namespace string, flagName string) ([]
 	return pvcs, nil
 }
 
-func commanderWrapper(cmd *cobra.Command, action string, args ...string) ([]string, error) {
+func startCommander(cmd *cobra.Command) (*commander.Commander, error) {
 	namespace, _ := cmd.Flags().GetString("namespace")
 	kubeConfig, _ := cmd.Flags().GetString("kubeconfig")
 	remote, _ := cmd.Flags().GetString("remote")
 	port, _ := cmd.Flags().GetUint("port")
+	knownHosts, _ := cmd.Flags().GetString("known-hosts")
+	knownHostsConfigMap, _ := cmd.Flags().GetString("known-hosts-configmap")
+	hostKeyFingerprint, _ := cmd.Flags().GetString("host-key-fingerprint")
+	insecureIgnoreHostKey, _ := cmd.Flags().GetBool("insecure-ignore-host-key")
+	keepAliveInterval, _ := cmd.Flags().GetDuration("keepalive-interval")
+	transport, _ := cmd.Flags().GetString("transport")
+	serverCA, _ := cmd.Flags().GetString("server-ca")
+	tlsCert, _ := cmd.Flags().GetString("tls-cert")
+	tlsKey, _ := cmd.Flags().GetString("tls-key")
+	apiTokenFile, _ := cmd.Flags().GetString("api-token-file")
+	connectTimeout, _ := cmd.Flags().GetDuration("connect-timeout")
+	identity, _ := cmd.Flags().GetString("identity")
+	certificate, _ := cmd.Flags().GetString("certificate")
+	identitySecret, _ := cmd.Flags().GetString("identity-secret")
+	actionTimeout, _ := cmd.Flags().GetDuration("action-timeout")
+	actionTimeouts, err := actionTimeoutsFlag(cmd)
+	if err != nil {
+		return nil, err
+	}
 
-	log.Debugf("Connecting to server %v:%d ...", remote, port)
 	config := commander.Config{
-		Namespace:  namespace,
-		KubeConfig: kubeConfig,
-		Remote:     remote,
-		Port:       port,
+		Version:               version,
+		Namespace:             namespace,
+		KubeConfig:            kubeConfig,
+		Remote:                remote,
+		Port:                  port,
+		KnownHostsFile:        knownHosts,
+		KnownHostsConfigMap:   knownHostsConfigMap,
+		HostKeyFingerprint:    hostKeyFingerprint,
+		InsecureIgnoreHostKey: insecureIgnoreHostKey,
+		KeepAliveInterval:     keepAliveInterval,
+		Transport:             commander.TransportType(transport),
+		ServerCAFile:          serverCA,
+		TLSCertFile:           tlsCert,
+		TLSKeyFile:            tlsKey,
+		APITokenFile:          apiTokenFile,
+		ConnectTimeout:        connectTimeout,
+		IdentityFile:          identity,
+		CertificateFile:       certificate,
+		IdentitySecret:        identitySecret,
+		ActionTimeout:         actionTimeout,
+		ActionTimeouts:        actionTimeouts,
 	}
+	return commander.StartClient(config)
+}
 
-	c, err := commander.StartClient(config)
+func commanderWrapper(c *commander.Commander, action string, args commander.Args) (*commander.Response, error) {
+	callArgs, err := commander.BuildArgs(action, args)
 	if err != nil {
 		return nil, err
 	}
-
-	defer func() {
-		log.Debugf("Closed ssh connection")
-		c.Close()
-	}()
-	output, err := c.Run(action, args...)
+	if RemoteNamespace != "" {
+		callArgs = append(callArgs, "--namespace", RemoteNamespace)
+	}
+	response, err := c.Call(action, callArgs...)
 	if err != nil {
 		return nil, err
 	}
-
-	return strings.Split(output, "\n"), nil
+	log.Debugf("[Response] %s: %s %s", action, response.Status, response.Payload)
+	return response, nil
 }
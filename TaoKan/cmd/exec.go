@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"TaoKan/commander"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var execCmd = &cobra.Command{
+	Use:   "exec <action> [args...]",
+	Short: "Run an action on the server in remote cluster",
+	Long: `Run an action on the server in remote cluster with the connection settings of the client,
+print the output of the server and exit with the exit status of the action.
+
+The arguments after the action are sent to the server as they are, e.g.
+
+  TaoKan client exec -r <remote> mount claim-alice
+  TaoKan client exec -r <remote> touch user alice --capacity 20Gi
+
+The text output is only served over ssh, use --json to print the response of the https transport.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+
+		c, err := startCommander(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if jsonOutput {
+			err = execJSON(c, args[0], args[1:])
+		} else {
+			var output string
+			output, err = c.Run(args[0], args[1:]...)
+			fmt.Print(output)
+		}
+		c.Close()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		os.Exit(commander.ExitStatusOf(err))
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(execCmd)
+
+	// The flags after the action belong to the action
+	execCmd.Flags().SetInterspersed(false)
+	execCmd.Flags().Bool("json", false, "Call the action with the JSON protocol and print the response")
+}
+
+func execJSON(c *commander.Commander, action string, args []string) error {
+	response, err := c.Call(action, args...)
+	if response == nil {
+		return err
+	}
+	data, marshalErr := json.MarshalIndent(response, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	fmt.Println(string(data))
+	return err
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"TaoKan/commander"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var purgeCmd = &cobra.Command{
+	Use:   "purge <pvc-name>",
+	Short: "Delete the pvc created by TaoKan in remote cluster",
+	Long: `Delete the pvc created by TaoKan in remote cluster.
+
+Without --confirm, it only shows the pvc to be deleted and the confirmation token.
+Run it again with --confirm <token> to delete the pvc.`,
+	Args: cobra.RangeArgs(1, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pvcName := args[0]
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		token, _ := cmd.Flags().GetString("confirm")
+
+		c, err := startCommander(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		err = checkCapabilities(c, "purge")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		callArgs := commander.Args{"pvc": pvcName}
+		if !dryRun && token != "" {
+			callArgs["token"] = token
+		}
+		response, err := commanderWrapper(c, "purge", callArgs)
+		if err != nil {
+			log.Fatalf("[Failed] Purge pvc %s: %v", pvcName, err)
+		}
+		var result commander.PurgeResult
+		err = response.Decode(&result)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if result.Deleted {
+			log.Infof("[Deleted] Pvc %s in remote cluster", result.Pvc)
+			return
+		}
+		log.Infof("[Dry Run] Pvc %s in remote cluster would be deleted", result.Pvc)
+		if !dryRun {
+			log.Infof("Run again with '--confirm %s' to delete it", result.Token)
+		} else {
+			log.Infof("Confirmation token: %s", result.Token)
+		}
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(purgeCmd)
+
+	purgeCmd.Flags().Bool("dry-run", false, "Only show the pvc to be deleted")
+	purgeCmd.Flags().String("confirm", "", "Confirmation token shown by the dry run")
+}
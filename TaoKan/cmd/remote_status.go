@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"TaoKan/commander"
+	KubernetesAPI "TaoKan/k8s"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var remoteStatusCmd = &cobra.Command{
+	Use:   "remote-status",
+	Short: "Show the pvcs in remote cluster and the pods using them",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, output := statusFlags(cmd)
+
+		c, err := startCommander(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		response, err := commanderWrapper(c, "status", nil)
+		c.Close()
+		if err != nil {
+			log.Error(err)
+			os.Exit(commander.ExitStatusOf(err))
+		}
+		var status commander.StatusResult
+		err = response.Decode(&status)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Filtered by the client, the earlier servers take no filters
+		records := make([]KubernetesAPI.PvcStatus, 0)
+		for _, record := range status.Records() {
+			if filter.Match(record) {
+				records = append(records, record)
+			}
+		}
+		err = KubernetesAPI.RenderPvcStatus(os.Stdout, records, output)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(remoteStatusCmd)
+
+	addStatusFlags(remoteStatusCmd)
+}
@@ -2,11 +2,17 @@ package cmd
 
 import (
 	"TaoKan/commander"
+	"context"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	v1 "k8s.io/api/core/v1"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 var serverPort uint
@@ -43,6 +49,40 @@ func init() {
 	serverCmd.Flags().String("storage-class", "", "Specify the storage class for RWO pvc")
 	serverCmd.Flags().String("storage-class-rwx", "", "Specify the storage class for RWX pvc")
 	serverCmd.PersistentFlags().Int32("retry", 3, "Rsync-server pod restart time")
+	serverCmd.Flags().String("authorized-keys", "", "Path of the authorized_keys file for client authentication")
+	serverCmd.Flags().String("authorized-keys-secret", commander.DefaultAuthorizedKeysSecret, "Secret which contains the authorized_keys for client authentication")
+	serverCmd.Flags().String("user-ca-keys", "", "Path of the CA public keys which sign the client certificates, one per line")
+	serverCmd.Flags().String("user-ca-keys-secret", "", "Secret which contains the CA public keys which sign the client certificates")
+	serverCmd.Flags().String("host-key", "", "Path of the PEM encoded ssh host key")
+	serverCmd.Flags().String("host-key-secret", commander.DefaultHostKeySecret, "Secret which stores the ssh host key, generated if not exists")
+	serverCmd.Flags().String("policy", "", "Path of the authorization policy file")
+	serverCmd.Flags().String("policy-configmap", "", "ConfigMap which contains the authorization policy, all actions are allowed if no policy")
+	serverCmd.Flags().String("audit-log", "", "Path of the audit log file, audit disabled if empty")
+	serverCmd.Flags().Int64("audit-log-max-size", 10, "Maximum size in megabytes of the audit log before it gets rotated")
+	serverCmd.Flags().Int("audit-log-max-backups", commander.DefaultAuditLogMaxBackups, "Number of the rotated audit log files to keep")
+	serverCmd.Flags().Bool("audit-events", false, "Mirror the audit entries to the Kubernetes events")
+	serverCmd.Flags().Duration("lock-wait", commander.DefaultLockWait, "How long an action waits for the lock of a busy pvc, 0 to fail fast")
+	serverCmd.Flags().Bool("lock-lease", false, "Back the pvc locks with coordination.k8s.io leases")
+	serverCmd.Flags().Uint("http-port", 0, "Port of the https api, disabled if 0")
+	serverCmd.Flags().String("tls-cert", "", "Path of the tls certificate of the https api")
+	serverCmd.Flags().String("tls-key", "", "Path of the tls certificate key of the https api")
+	serverCmd.Flags().String("client-ca", "", "Path of the CA certificate to verify the client certificates of the https api")
+	serverCmd.Flags().String("api-tokens", "", "Path of the bearer tokens file of the https api, one '<name>:<token>' per line")
+	serverCmd.Flags().String("api-tokens-secret", "", "Secret which contains the bearer tokens of the https api, keyed by the token names")
+	serverCmd.Flags().StringSlice("allowed-namespaces", nil, "Namespaces the actions may target besides the server namespace, glob patterns allowed")
+	serverCmd.Flags().Bool("create-namespace", false, "Create the target namespace if it does not exist")
+	serverCmd.Flags().Int("max-sessions", 0, "Maximum of the concurrent ssh sessions and https requests, unlimited if 0")
+	serverCmd.Flags().Int("max-mounts", 0, "Maximum of the rsync-server pods mounting the pvcs in the cluster, unlimited if 0")
+	serverCmd.Flags().Float64("rate-limit", 0, "Maximum requests per second of each client key, unlimited if 0")
+	serverCmd.Flags().Int("rate-burst", commander.DefaultRateBurst, "Burst of the requests of each client key above the rate limit")
+	serverCmd.Flags().StringToString("max-capacity", nil, "Maximum capacity of each pvc type for touch, e.g. user=50Gi,project=1Ti")
+	serverCmd.Flags().String("storage-budget", "", "Total capacity of the pvcs created by TaoKan in the cluster, unlimited if empty")
+	serverCmd.Flags().Duration("idle-timeout", 0, "Close the ssh connections idle for this long, no timeout if 0")
+	serverCmd.Flags().Duration("conn-lifetime", 0, "Close each ssh connection this long after it opened, cutting the actions in flight on it, unlimited if 0")
+	serverCmd.Flags().Duration("keepalive-interval", commander.DefaultKeepAliveInterval, "Interval of the keepalive messages to the connected clients, disabled if 0")
+	serverCmd.Flags().Duration("action-timeout", commander.DefaultActionTimeout, "Deadline of each action, no deadline if 0")
+	serverCmd.Flags().StringToString("action-timeouts", nil, "Deadline of the specified actions, e.g. mount=10m,verify=1h")
+	serverCmd.Flags().Duration("drain-timeout", commander.DefaultDrainTimeout, "How long to wait for the in-flight actions on shutdown")
 }
 
 func serverEntrypoint(cmd *cobra.Command, args []string) {
@@ -66,13 +106,117 @@ func serverEntrypoint(cmd *cobra.Command, args []string) {
 		log.Infof("default storage class for RWX : %s", rwx)
 	}
 
-	log.Infof("Start ssh server at %d", serverPort)
+	authorizedKeysFile, _ := cmd.Flags().GetString("authorized-keys")
+	authorizedKeysSecret, _ := cmd.Flags().GetString("authorized-keys-secret")
+	userCAKeysFile, _ := cmd.Flags().GetString("user-ca-keys")
+	userCAKeysSecret, _ := cmd.Flags().GetString("user-ca-keys-secret")
+	hostKeyFile, _ := cmd.Flags().GetString("host-key")
+	hostKeySecret, _ := cmd.Flags().GetString("host-key-secret")
+	policyFile, _ := cmd.Flags().GetString("policy")
+	policyConfigMap, _ := cmd.Flags().GetString("policy-configmap")
+	auditLogFile, _ := cmd.Flags().GetString("audit-log")
+	auditLogMaxSize, _ := cmd.Flags().GetInt64("audit-log-max-size")
+	auditLogMaxBackups, _ := cmd.Flags().GetInt("audit-log-max-backups")
+	auditEvents, _ := cmd.Flags().GetBool("audit-events")
+	lockWait, _ := cmd.Flags().GetDuration("lock-wait")
+	lockLease, _ := cmd.Flags().GetBool("lock-lease")
+	drainTimeout, _ := cmd.Flags().GetDuration("drain-timeout")
+	httpPort, _ := cmd.Flags().GetUint("http-port")
+	tlsCert, _ := cmd.Flags().GetString("tls-cert")
+	tlsKey, _ := cmd.Flags().GetString("tls-key")
+	clientCA, _ := cmd.Flags().GetString("client-ca")
+	apiTokens, _ := cmd.Flags().GetString("api-tokens")
+	apiTokensSecret, _ := cmd.Flags().GetString("api-tokens-secret")
+	allowedNamespaces, _ := cmd.Flags().GetStringSlice("allowed-namespaces")
+	createNamespace, _ := cmd.Flags().GetBool("create-namespace")
+	maxSessions, _ := cmd.Flags().GetInt("max-sessions")
+	maxMounts, _ := cmd.Flags().GetInt("max-mounts")
+	rateLimit, _ := cmd.Flags().GetFloat64("rate-limit")
+	rateBurst, _ := cmd.Flags().GetInt("rate-burst")
+	maxCapacity, _ := cmd.Flags().GetStringToString("max-capacity")
+	storageBudget, _ := cmd.Flags().GetString("storage-budget")
+	idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+	connLifetime, _ := cmd.Flags().GetDuration("conn-lifetime")
+	keepAliveInterval, _ := cmd.Flags().GetDuration("keepalive-interval")
+	actionTimeout, _ := cmd.Flags().GetDuration("action-timeout")
+	actionTimeouts, err := actionTimeoutsFlag(cmd)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	config := commander.Config{
-		KubeConfig:      KubeConfig,
-		Namespace:       Namespace,
-		Port:            serverPort,
-		StorageClassRWO: rwo,
-		StorageClassRWX: rwx,
+		Version:              version,
+		KubeConfig:           KubeConfig,
+		Namespace:            Namespace,
+		Port:                 serverPort,
+		StorageClassRWO:      rwo,
+		StorageClassRWX:      rwx,
+		AuthorizedKeysFile:   authorizedKeysFile,
+		AuthorizedKeysSecret: authorizedKeysSecret,
+		UserCAKeysFile:       userCAKeysFile,
+		UserCAKeysSecret:     userCAKeysSecret,
+		HostKeyFile:          hostKeyFile,
+		HostKeySecret:        hostKeySecret,
+		PolicyFile:           policyFile,
+		PolicyConfigMap:      policyConfigMap,
+		AuditLogFile:         auditLogFile,
+		AuditLogMaxSize:      auditLogMaxSize * 1024 * 1024,
+		AuditLogMaxBackups:   auditLogMaxBackups,
+		AuditEvents:          auditEvents,
+		LockWait:             lockWait,
+		LockLease:            lockLease,
+		HTTPPort:             httpPort,
+		TLSCertFile:          tlsCert,
+		TLSKeyFile:           tlsKey,
+		ClientCAFile:         clientCA,
+		APITokensFile:        apiTokens,
+		APITokensSecret:      apiTokensSecret,
+		AllowedNamespaces:    allowedNamespaces,
+		CreateNamespace:      createNamespace,
+		MaxSessions:          maxSessions,
+		MaxMounts:            maxMounts,
+		RateLimit:            rateLimit,
+		RateBurst:            rateBurst,
+		MaxCapacity:          maxCapacity,
+		StorageBudget:        storageBudget,
+		IdleTimeout:          idleTimeout,
+		ConnLifetime:         connLifetime,
+		KeepAliveInterval:    keepAliveInterval,
+		ActionTimeout:        actionTimeout,
+		ActionTimeouts:       actionTimeouts,
+	}
+	server, err := commander.NewServer(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	err = server.Start(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Infof("[Shutdown] Received signal, drain timeout: %v", drainTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Errorf("[Shutdown] %v", err)
+	}
+	log.Infoln("TaoKan server stopped")
+}
+
+// actionTimeoutsFlag parses the deadlines of the --action-timeouts flag, keyed by the action names
+func actionTimeoutsFlag(cmd *cobra.Command) (map[string]time.Duration, error) {
+	values, _ := cmd.Flags().GetStringToString("action-timeouts")
+	timeouts := map[string]time.Duration{}
+	for action, value := range values {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout of action %s: %v", action, err)
+		}
+		timeouts[action] = timeout
 	}
-	commander.StartServer(config)
+	return timeouts, nil
 }
@@ -0,0 +1,49 @@
+package cmd
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the pvcs in the cluster and the pods using them",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		filter, output := statusFlags(cmd)
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		records, err := k8s.ListPvcStatus(Namespace, filter)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = KubernetesAPI.RenderPvcStatus(os.Stdout, records, output)
+		if err != nil {
+			log.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+
+	addStatusFlags(statusCmd)
+}
+
+// addStatusFlags adds the output format and the filters shared by status and remote-status
+func addStatusFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "table", "Output format: "+strings.Join(KubernetesAPI.PvcStatusFormats, ", "))
+	cmd.Flags().StringSlice("type", nil, "Only the pvcs of the types: "+strings.Join(KubernetesAPI.PvcStatusTypes, ", "))
+	cmd.Flags().String("name", "", "Only the pvcs whose name matches the glob pattern")
+	cmd.Flags().Bool("in-use", false, "Only the pvcs used by pods")
+}
+
+func statusFlags(cmd *cobra.Command) (KubernetesAPI.PvcStatusFilter, string) {
+	output, _ := cmd.Flags().GetString("output")
+	types, _ := cmd.Flags().GetStringSlice("type")
+	name, _ := cmd.Flags().GetString("name")
+	inUse, _ := cmd.Flags().GetBool("in-use")
+	return KubernetesAPI.PvcStatusFilter{Types: types, Name: name, InUseOnly: inUse}, output
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"TaoKan/commander"
+	KubernetesAPI "TaoKan/k8s"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"strconv"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <pvc-name>",
+	Short: "Verify the data of the pvc with the one in remote cluster",
+	Long: `Verify the data of the pvc with the one in remote cluster by their manifests.
+
+Exits with 65 if the manifests differ, or with the exit status of the failed action.`,
+	Args: cobra.RangeArgs(1, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		pvcName := args[0]
+		checksum, _ := cmd.Flags().GetBool("checksum")
+
+		log.Infof("Start TaoKan to verify pvc %s with remote cluster", pvcName)
+		c, err := startCommander(cmd)
+		if err != nil {
+			log.Error(err)
+			os.Exit(commander.ExitStatusOf(err))
+		}
+		defer c.Close()
+		err = checkCapabilities(c, "mount", "umount", "verify")
+		if err != nil {
+			log.Error(err)
+			c.Close()
+			os.Exit(commander.ExitStatusOf(err))
+		}
+
+		diff, err := verifyPvcData(c, pvcName, checksum)
+		if err != nil {
+			// The failures exit by their error codes, apart from the diverged manifests
+			log.Errorf("[Failed] Verify pvc %s: %v", pvcName, err)
+			c.Close()
+			os.Exit(commander.ExitStatusOf(err))
+		}
+
+		for _, path := range diff.Missing {
+			log.Warnf("[Missing] %s", path)
+		}
+		for _, path := range diff.Extra {
+			log.Warnf("[Extra] %s", path)
+		}
+		for _, mismatch := range diff.Mismatched {
+			log.Warnf("[Mismatched] %s: %s", mismatch.Path, mismatch.Reason)
+		}
+		log.Infof("[Summary] missing: %d, extra: %d, mismatched: %d", len(diff.Missing), len(diff.Extra), len(diff.Mismatched))
+		if diff.Diverged() {
+			c.Close()
+			os.Exit(commander.ExitDiverged)
+		}
+		log.Infof("[Verified] Pvc %s is identical with remote cluster", pvcName)
+	},
+}
+
+func init() {
+	clientCmd.AddCommand(verifyCmd)
+
+	verifyCmd.Flags().Bool("checksum", false, "Compare the sha256 checksum of files")
+}
+
+func verifyPvcData(c *commander.Commander, pvcName string, checksum bool) (commander.ManifestDiff, error) {
+	var diff commander.ManifestDiff
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	_, usedByPods, err := k8s.GetPvc(Namespace, pvcName)
+	if err != nil {
+		return diff, err
+	}
+	// The rsync-verifier pod mounts the pvc, which must not be used by another pod
+	if len(usedByPods) > 0 {
+		return diff, &commander.Error{
+			Code:    commander.ErrCodePvcInUse,
+			Message: fmt.Sprintf("pvc %s is used by pod %s", pvcName, usedByPods[0].Name),
+		}
+	}
+
+	// Remote manifest by the rsync-server pod
+	log.Infof("[Mount] Pvc %s in remote cluster", pvcName)
+	response, err := commanderWrapper(c, "mount", commander.Args{"pvc": pvcName})
+	if err != nil {
+		return diff, err
+	}
+	var mountResult commander.MountResult
+	err = response.Decode(&mountResult)
+	if err != nil {
+		return diff, err
+	}
+	if !mountResult.Ready {
+		return diff, errors.New("rsync-server not running")
+	}
+	defer func() {
+		log.Infof("[Unmount] Pvc %s in remote cluster", pvcName)
+		commanderWrapper(c, "umount", commander.Args{"pvc": pvcName})
+	}()
+
+	verifyArgs := commander.Args{"pvc": pvcName, "checksum": strconv.FormatBool(checksum)}
+	response, err = commanderWrapper(c, "verify", verifyArgs)
+	if err != nil {
+		return diff, err
+	}
+	var remote commander.Manifest
+	err = response.Decode(&remote)
+	if err != nil {
+		return diff, err
+	}
+	log.Infof("[Remote] Pvc %s files: %d", pvcName, len(remote.Entries))
+
+	// Source manifest by the rsync-verifier pod
+	pod, err := k8s.LaunchRsyncVerifierPod(Namespace, pvcName)
+	if err != nil {
+		return diff, err
+	}
+	defer k8s.DeletePod(Namespace, pod.Name)
+
+	output, err := k8s.ExecInPod(Namespace, pod.Name, commander.ManifestCommand(checksum))
+	if err != nil {
+		return diff, err
+	}
+	source, err := commander.ParseManifest(output)
+	if err != nil {
+		return diff, err
+	}
+	log.Infof("[Source] Pvc %s files: %d", pvcName, len(source))
+
+	return commander.DiffManifest(source, remote.Entries), nil
+}
@@ -2,17 +2,17 @@ package commander
 
 import (
 	KubernetesAPI "TaoKan/k8s"
-	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"io"
 	v1 "k8s.io/api/core/v1"
 	"strings"
+	"time"
 )
 
-func getRsyncServerStatus(pvcName string) (string, string, error) {
+func getRsyncServerStatus(namespace string, pvcName string) (string, string, error) {
 	k8s := KubernetesAPI.GetInstance(KubeConfig)
-	_, usedByPods, err := k8s.GetPvc(Namespace, pvcName)
+	_, usedByPods, err := k8s.GetPvc(namespace, pvcName)
 	if err != nil {
 		return "", "", err
 	}
@@ -35,62 +35,85 @@ func getRsyncServerStatus(pvcName string) (string, string, error) {
 	return rsyncServer, phase, nil
 }
 
-func status(w io.Writer, args []string) error {
+func status(w io.Writer, namespace string, args Args) (interface{}, error) {
+	filter := KubernetesAPI.PvcStatusFilter{Name: args.String("name"), InUseOnly: args.Bool("in-use")}
+	if args.Has("type") {
+		filter.Types = []string{args.String("type")}
+	}
 	k8s := KubernetesAPI.GetInstance(KubeConfig)
-	var result string
-
-	log.Infof("List User PVC ...")
-	userPvcs, err := k8s.ListUserPvc(Namespace)
+	records, err := k8s.ListPvcStatus(namespace, filter)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	io.WriteString(w, "[User] PVC\n")
-	result, err = k8s.ShowPvcStatus(Namespace, userPvcs)
+	log.Infof("[Status] Found %d PVCs", len(records))
+	err = KubernetesAPI.RenderPvcStatus(w, records, args.String("output"))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	io.WriteString(w, result)
-	log.Infof("Found %d PVCs", len(userPvcs))
+	return newStatusResult(records), nil
+}
 
-	log.Infof("List Dataset PVC ...")
-	datasetPvcs, err := k8s.ListDatasetPvc(Namespace)
-	if err != nil {
-		return err
-	}
-	io.WriteString(w, "[Dataset] PVC\n")
-	result, err = k8s.ShowPvcStatus(Namespace, datasetPvcs)
+func statPvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcName := args.String("pvc")
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	pvc, usedByPods, err := k8s.GetPvc(namespace, pvcName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	io.WriteString(w, result)
-	log.Infof("Found %d PVCs", len(datasetPvcs))
 
-	log.Infof("List Project PVC ...")
-	projectPvcs, err := k8s.ListProjectPvc(Namespace)
-	if err != nil {
-		return err
+	stat := PvcStat{
+		Name:              pvc.Name,
+		Namespace:         pvc.Namespace,
+		Phase:             string(pvc.Status.Phase),
+		RequestedCapacity: pvc.Spec.Resources.Requests.Storage().String(),
+		VolumeName:        pvc.Spec.VolumeName,
+		CreationTimestamp: pvc.CreationTimestamp.Time,
+		Annotations:       map[string]string{},
 	}
-	io.WriteString(w, "[Project] PVC\n")
-	result, err = k8s.ShowPvcStatus(Namespace, projectPvcs)
-	if err != nil {
-		return err
+	if capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok {
+		stat.Capacity = capacity.String()
 	}
-	io.WriteString(w, result)
-	log.Infof("Found %d PVCs", len(projectPvcs))
+	if pvc.Spec.StorageClassName != nil {
+		stat.StorageClass = *pvc.Spec.StorageClassName
+	}
+	for _, accessMode := range pvc.Spec.AccessModes {
+		stat.AccessModes = append(stat.AccessModes, string(accessMode))
+	}
+	for key, value := range pvc.Annotations {
+		if strings.HasPrefix(key, KubernetesAPI.TaoKanAnnotationPrefix) {
+			stat.Annotations[key] = value
+		}
+	}
+	for _, pod := range usedByPods {
+		if pod.Labels["managed-by"] == "TaoKan" && pod.Labels["role"] == "rsync-server" {
+			stat.RsyncServer = pod.Name
+		}
+		stat.UsedBy = append(stat.UsedBy, pod.Name)
+	}
+
+	fmt.Fprintf(w, "Name:          %s\n", stat.Name)
+	fmt.Fprintf(w, "Namespace:     %s\n", stat.Namespace)
+	fmt.Fprintf(w, "Phase:         %s\n", stat.Phase)
+	fmt.Fprintf(w, "Capacity:      %s (requested %s)\n", stat.Capacity, stat.RequestedCapacity)
+	fmt.Fprintf(w, "StorageClass:  %s\n", stat.StorageClass)
+	fmt.Fprintf(w, "AccessModes:   %s\n", strings.Join(stat.AccessModes, ","))
+	fmt.Fprintf(w, "Volume:        %s\n", stat.VolumeName)
+	fmt.Fprintf(w, "Created:       %s\n", stat.CreationTimestamp.Format(time.RFC3339))
+	for key, value := range stat.Annotations {
+		fmt.Fprintf(w, "Annotation:    %s=%s\n", key, value)
+	}
+	fmt.Fprintf(w, "Used by:       %s\n", strings.Join(stat.UsedBy, ","))
 
-	return nil
+	return stat, nil
 }
 
-func mountPvc(w io.Writer, args []string) error {
-	if len(args) < 1 {
-		return errors.New("should provide PVC")
-	}
-	pvcName := args[0]
+func mountPvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcName := args.String("pvc")
 	k8s := KubernetesAPI.GetInstance(KubeConfig)
 	result := ""
-	serverPod, phase, err := getRsyncServerStatus(pvcName)
+	serverPod, phase, err := getRsyncServerStatus(namespace, pvcName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if serverPod != "" && phase == "Running" {
@@ -99,67 +122,185 @@ func mountPvc(w io.Writer, args []string) error {
 		if serverPod != "" {
 			log.Warnf("[Restart] Pod %s phase: %s", serverPod, phase)
 			log.Infof("[Delete] Pod %s", serverPod)
-			k8s.DeletePod(Namespace, serverPod)
+			k8s.DeletePod(namespace, serverPod)
 		}
 
+		if namespace != Namespace {
+			err = prepareNamespace(namespace)
+			if err != nil {
+				return nil, err
+			}
+		}
+		release, err := throttle.reserveMount(namespace, pvcName)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
 		log.Infoln("[Launch] rsync-server to mount pvc " + pvcName)
-		err := k8s.LaunchRsyncServerPod(Namespace, pvcName)
+		err = k8s.LaunchRsyncServerPod(namespace, pvcName, reportProgress(w, pvcName))
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
 	result = "Server pod ready: rsync-server-" + pvcName
 	io.WriteString(w, result)
 
-	return nil
+	return MountResult{
+		Pvc:       pvcName,
+		ServerPod: "rsync-server-" + pvcName,
+		Ready:     true,
+	}, nil
 }
 
-func umountPvc(w io.Writer, args []string) error {
-	if len(args) < 1 {
-		return errors.New("should provide PVC")
-	}
-	pvcName := args[0]
+func umountPvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcName := args.String("pvc")
 
 	k8s := KubernetesAPI.GetInstance(KubeConfig)
-	serverPod, _, err := getRsyncServerStatus(pvcName)
+	serverPod, _, err := getRsyncServerStatus(namespace, pvcName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if serverPod != "" {
 		log.Infof("[Delete] Pod %s", serverPod)
-		go k8s.DeletePod(Namespace, serverPod)
+		// Keep the pvc locked until the pod is gone, so the following mount won't see the terminating pod
+		holder := locks.detach(lockKey(namespace, pvcName))
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			defer holder.unlock()
+			err := k8s.DeletePod(namespace, serverPod)
+			if err != nil {
+				log.Errorf("[Delete] Pod %s failed: %v", serverPod, err)
+			}
+		}()
 	}
-	return nil
+	return UmountResult{Pvc: pvcName, ServerPod: serverPod}, nil
 }
 
-func touchPvc(w io.Writer, args []string) error {
-	argc := len(args)
-	if argc != 3 && argc != 4 {
-		return fmt.Errorf("invalid number of arguments: %d", argc)
-	}
-	pvcType := args[0]
-	name := args[1]
-	capacity := args[2]
+func touchPvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcType := args.String("type")
+	name := args.String("name")
+	capacity := args.String("capacity")
 
+	target, err := touchTarget(namespace, args)
+	if err != nil {
+		return nil, err
+	}
+	unlock := quota.lock()
+	defer unlock()
 	k8s := KubernetesAPI.GetInstance(KubeConfig)
-	var err error
+	if namespace != Namespace {
+		err = prepareNamespace(namespace)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = quota.check(namespace, target.PvcType, target.PvcName, *target.Capacity)
+	if err != nil {
+		return nil, err
+	}
 	switch pvcType {
 	case "user":
-		err = k8s.CreateUserPvc(Namespace, name, capacity)
+		err = k8s.CreateUserPvc(namespace, name, capacity)
 	case "project":
-		err = k8s.CreateProjectPvc(Namespace, name, capacity)
+		err = k8s.CreateProjectPvc(namespace, name, capacity)
 	case "dataset":
-		err = k8s.CreateDatasetPvc(Namespace, name, capacity)
+		err = k8s.CreateDatasetPvc(namespace, name, capacity)
 	case "raw":
-		if argc != 4 {
-			return fmt.Errorf("invalid number of arguments: %d", argc)
+		if !args.Has("accessMode") {
+			return nil, newError(ErrCodeInvalidArguments, "should provide accessMode of the raw pvc")
 		}
-		accessMode := args[3]
-		err = k8s.CreateRawPvc(Namespace, name, capacity, v1.PersistentVolumeAccessMode(accessMode))
+		accessMode := args.String("accessMode")
+		err = k8s.CreateRawPvc(namespace, name, capacity, v1.PersistentVolumeAccessMode(accessMode))
 	default:
-		err = errors.New("unsupported PVC type")
+		err = newError(ErrCodeInvalidArguments, "unsupported PVC type '%s'", pvcType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return TouchResult{Type: pvcType, Name: name, Capacity: capacity}, nil
+}
+
+// prepareNamespace copies the ssh key secret of the rsync-server pods to the target namespace,
+// which is created first if the server is configured to
+func prepareNamespace(namespace string) error {
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	if createNamespace {
+		err := k8s.EnsureNamespace(namespace)
+		if err != nil {
+			return err
+		}
+	}
+	return k8s.CopySecret(Namespace, namespace, KubernetesAPI.RsyncSshKeySecret)
+}
+
+// purgeToken is the confirmation token of purging the pvc, it changes when the pvc is recreated
+func purgeToken(pvc *v1.PersistentVolumeClaim) string {
+	token := string(pvc.UID)
+	if len(token) > 8 {
+		token = token[:8]
+	}
+	return token
+}
+
+func purgePvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcName := args.String("pvc")
+
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	pvc, usedByPods, err := k8s.GetPvc(namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+	if pvc.Labels["managed-by"] != "TaoKan" {
+		return nil, newError(ErrCodeForbidden, "pvc %s is not created by TaoKan", pvcName)
+	}
+	if len(usedByPods) > 0 {
+		return nil, newError(ErrCodePvcInUse, "pvc %s is used by pod %s", pvcName, usedByPods[0].Name)
+	}
+
+	token := purgeToken(pvc)
+	if !args.Has("token") {
+		fmt.Fprintf(w, "[Dry Run] Pvc %s would be deleted, confirm with token: %s\n", pvcName, token)
+		return PurgeResult{Pvc: pvcName, DryRun: true, Token: token}, nil
+	}
+	if args.String("token") != token {
+		return nil, newError(ErrCodeInvalidArguments, "confirmation token mismatch for pvc %s", pvcName)
+	}
+
+	log.Warnf("[Purge] Pvc %s", pvcName)
+	err = k8s.DeletePvc(namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "[Deleted] Pvc %s\n", pvcName)
+	return PurgeResult{Pvc: pvcName, Deleted: true}, nil
+}
+
+func verifyPvc(w io.Writer, namespace string, args Args) (interface{}, error) {
+	pvcName := args.String("pvc")
+	checksum := args.Bool("checksum")
+
+	serverPod, phase, err := getRsyncServerStatus(namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+	if serverPod == "" || phase != string(v1.PodRunning) {
+		return nil, newError(ErrCodePvcNotMounted, "pvc %s is not mounted by rsync-server", pvcName)
+	}
+
+	log.Infof("[Verify] Pvc %s checksum: %v", pvcName, checksum)
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	output, err := k8s.ExecInPod(namespace, serverPod, ManifestCommand(checksum))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseManifest(output)
+	if err != nil {
+		return nil, err
 	}
-	return err
+	io.WriteString(w, output)
+	log.Infof("[Verified] Pvc %s files: %d", pvcName, len(entries))
+	return Manifest{Pvc: pvcName, Entries: entries}, nil
 }
@@ -0,0 +1,235 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultAuditLogMaxSize    = 10 * 1024 * 1024
+	DefaultAuditLogMaxBackups = 5
+	DefaultAuditEntries       = 20
+)
+
+// AuditEntry is a record of the command executed by the server
+type AuditEntry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Address     string    `json:"address"`
+	Fingerprint string    `json:"fingerprint"`
+	Namespace   string    `json:"namespace,omitempty"`
+	Action      string    `json:"action"`
+	Args        []string  `json:"args,omitempty"`
+	Result      string    `json:"result"`
+	Message     string    `json:"message,omitempty"`
+	DurationMs  int64     `json:"durationMs"`
+}
+
+// AuditLogger appends the audit entries to a size rotated file as json lines,
+// and optionally mirrors them to the Kubernetes events
+type AuditLogger struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	events     bool
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+var auditLogger *AuditLogger
+
+// newAuditLogger returns nil if the audit log is disabled
+func newAuditLogger(config Config) (*AuditLogger, error) {
+	if config.AuditLogFile == "" {
+		log.Warnf("[Audit] Audit log disabled")
+		return nil, nil
+	}
+	a := &AuditLogger{
+		path:       config.AuditLogFile,
+		maxSize:    config.AuditLogMaxSize,
+		maxBackups: config.AuditLogMaxBackups,
+		events:     config.AuditEvents,
+	}
+	if a.maxSize <= 0 {
+		a.maxSize = DefaultAuditLogMaxSize
+	}
+	if a.maxBackups <= 0 {
+		a.maxBackups = DefaultAuditLogMaxBackups
+	}
+
+	err := os.MkdirAll(filepath.Dir(a.path), 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = a.open()
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("[Audit] Write audit log to %s", a.path)
+	return a, nil
+}
+
+func (a *AuditLogger) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// rotate shifts audit.log to audit.log.1, audit.log.1 to audit.log.2 and so on
+func (a *AuditLogger) rotate() error {
+	a.file.Close()
+	for i := a.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	err := os.Rename(a.path, a.path+".1")
+	if err != nil {
+		return err
+	}
+	return a.open()
+}
+
+func (a *AuditLogger) Record(entry AuditEntry, target *actionTarget) {
+	if a == nil {
+		return
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Errorf("[Audit] %v", err)
+		return
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	if a.size+int64(len(data)) > a.maxSize {
+		err = a.rotate()
+		if err != nil {
+			log.Errorf("[Audit] Rotate %s failed: %v", a.path, err)
+		}
+	}
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	a.mu.Unlock()
+	if err != nil {
+		log.Errorf("[Audit] Write %s failed: %v", a.path, err)
+	}
+
+	if a.events {
+		go a.mirrorToEvent(entry, target)
+	}
+}
+
+func (a *AuditLogger) mirrorToEvent(entry AuditEntry, target *actionTarget) {
+	namespace, kind, name := Namespace, "Pod", os.Getenv("HOSTNAME")
+	if target != nil {
+		namespace, kind, name = entry.Namespace, "PersistentVolumeClaim", target.PvcName
+	}
+	eventType := "Normal"
+	if entry.Result != string(StatusOK) {
+		eventType = "Warning"
+	}
+	message := fmt.Sprintf("%s %s by %s from %s: %s", entry.Action, strings.Join(entry.Args, " "), entry.Fingerprint, entry.Address, entry.Result)
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	err := k8s.CreateEvent(namespace, kind, name, eventType, "TaoKanAudit", message)
+	if err != nil {
+		log.Warnf("[Audit] Mirror to event failed: %v", err)
+	}
+}
+
+// Recent returns the last n entries from the current and the rotated files
+func (a *AuditLogger) Recent(n int) ([]AuditEntry, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var entries []AuditEntry
+	for i := 0; i <= a.maxBackups && len(entries) < n; i++ {
+		path := a.path
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", a.path, i)
+		}
+		fileEntries, err := readAuditFile(path)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(fileEntries, entries...)
+	}
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	return entries, nil
+}
+
+func readAuditFile(path string) ([]AuditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+func newAuditEntry(caller Caller, namespace string, commands []string, err error, duration time.Duration) AuditEntry {
+	entry := AuditEntry{
+		Timestamp:   time.Now().UTC(),
+		Address:     caller.Address,
+		Fingerprint: caller.Fingerprint,
+		Namespace:   namespace,
+		Action:      commands[0],
+		Args:        commands[1:],
+		Result:      string(StatusOK),
+		DurationMs:  duration.Milliseconds(),
+	}
+	if err != nil {
+		e := toError(err)
+		entry.Result = string(e.Code)
+		entry.Message = e.Message
+	}
+	return entry
+}
+
+func audit(w io.Writer, namespace string, args Args) (interface{}, error) {
+	if auditLogger == nil {
+		return nil, newError(ErrCodeUnsupportedCommand, "audit log is disabled")
+	}
+	entries, err := auditLogger.Recent(args.Int("limit"))
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%s %s %s %s %s [%s] %dms\n",
+			entry.Timestamp.Format(time.RFC3339), entry.Address, entry.Fingerprint,
+			entry.Action, strings.Join(entry.Args, " "), entry.Result, entry.DurationMs)
+	}
+	return entries, nil
+}
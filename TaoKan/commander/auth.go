@@ -0,0 +1,137 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"os"
+)
+
+const (
+	DefaultAuthorizedKeysSecret = "taokan-authorized-keys"
+	AuthorizedKeysSecretKey     = "authorized_keys"
+)
+
+type authorizedKey struct {
+	key     gossh.PublicKey
+	comment string
+}
+
+// authorizedKeys holds the client keys allowed to connect, indexed by their SHA256 fingerprint
+type authorizedKeys map[string]authorizedKey
+
+func parseAuthorizedKeys(data []byte) (authorizedKeys, error) {
+	keys := authorizedKeys{}
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, comment, _, rest, err := gossh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, err
+		}
+		keys[gossh.FingerprintSHA256(key)] = authorizedKey{key: key, comment: comment}
+		data = rest
+	}
+	return keys, nil
+}
+
+// readKeys reads the keys of the file and of the secret in the server namespace, either may be empty
+func readKeys(kind, file, secretName, secretKey string) ([]byte, error) {
+	var data []byte
+	if file != "" {
+		log.Infof("[Load] %s from file %s", kind, file)
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, content...)
+		data = append(data, '\n')
+	}
+	if secretName != "" {
+		log.Infof("[Load] %s from secret %s/%s", kind, Namespace, secretName)
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		secret, err := k8s.GetSecret(Namespace, secretName)
+		if err != nil {
+			return nil, err
+		}
+		content, ok := secret.Data[secretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key '%s'", secretName, secretKey)
+		}
+		data = append(data, content...)
+	}
+	return data, nil
+}
+
+// loadAuthorizedKeys loads the client keys, there may be none if the clients present certificates
+func loadAuthorizedKeys(config Config) (authorizedKeys, error) {
+	data, err := readKeys("Authorized keys", config.AuthorizedKeysFile, config.AuthorizedKeysSecret, AuthorizedKeysSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAuthorizedKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	for fingerprint, key := range keys {
+		log.Infof("[Authorized] %s %s", fingerprint, key.comment)
+	}
+	return keys, nil
+}
+
+// clientAuth authenticates the clients by their authorized keys or their certificates signed by the user CA
+type clientAuth struct {
+	keys authorizedKeys
+	ca   *userCA
+}
+
+func loadClientAuth(config Config) (*clientAuth, error) {
+	keys, err := loadAuthorizedKeys(config)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := loadUserCA(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 && ca == nil {
+		return nil, errors.New("no authorized keys or user CA keys configured")
+	}
+	return &clientAuth{keys: keys, ca: ca}, nil
+}
+
+func (a *clientAuth) publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	// The permissions are shared by the keys offered on the connection, only a certificate restricts them
+	ctx.Permissions().CriticalOptions = nil
+	if cert, ok := key.(*gossh.Certificate); ok && a.ca != nil {
+		return a.ca.publicKeyHandler(ctx, cert)
+	}
+	return a.keys.publicKeyHandler(ctx, key)
+}
+
+func (keys authorizedKeys) publicKeyHandler(ctx ssh.Context, key ssh.PublicKey) bool {
+	fingerprint := gossh.FingerprintSHA256(key)
+	authorized, ok := keys[fingerprint]
+	if !ok || !ssh.KeysEqual(key, authorized.key) {
+		log.Warnf("[Rejected] User: %s Address: %s Key: %s", ctx.User(), ctx.RemoteAddr(), fingerprint)
+		return false
+	}
+	return true
+}
+
+// sessionCaller identifies the client by the key which authenticated the session. The public key handler
+// also checks the keys offered without a signature, so it records nothing about the caller.
+func sessionCaller(session ssh.Session) Caller {
+	caller := Caller{Address: session.RemoteAddr().String()}
+	key := session.PublicKey()
+	if key == nil {
+		return caller
+	}
+	if cert, ok := key.(*gossh.Certificate); ok {
+		return certificateCaller(caller, cert)
+	}
+	caller.Fingerprint = gossh.FingerprintSHA256(key)
+	return caller
+}
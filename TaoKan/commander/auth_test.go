@@ -0,0 +1,91 @@
+package commander
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/gliderlabs/ssh"
+	gossh "golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"testing"
+)
+
+func newTestSigner(t *testing.T) gossh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// forgedSigner offers the public key of another client, the server accepts the key without a signature
+// but rejects the forged signature
+type forgedSigner struct {
+	key gossh.PublicKey
+}
+
+func (s forgedSigner) PublicKey() gossh.PublicKey {
+	return s.key
+}
+
+func (s forgedSigner) Sign(rand io.Reader, data []byte) (*gossh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s forgedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*gossh.Signature, error) {
+	return &gossh.Signature{Format: "forged", Blob: data}, nil
+}
+
+func TestSessionCallerIsTheAuthenticatedKey(t *testing.T) {
+	victim := newTestSigner(t)
+	attacker := newTestSigner(t)
+	keys := authorizedKeys{}
+	for _, signer := range []gossh.Signer{victim, attacker} {
+		keys[gossh.FingerprintSHA256(signer.PublicKey())] = authorizedKey{key: signer.PublicKey()}
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ssh.Server{
+		Handler: func(session ssh.Session) {
+			io.WriteString(session, sessionCaller(session).Fingerprint)
+		},
+		PublicKeyHandler: keys.publicKeyHandler,
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// The attacker's key and then the victim's key are checked without a valid signature,
+	// and the attacker authenticates with its own key at last
+	client, err := gossh.Dial("tcp", listener.Addr().String(), &gossh.ClientConfig{
+		User: "rsync",
+		Auth: []gossh.AuthMethod{gossh.PublicKeys(
+			forgedSigner{key: attacker.PublicKey()},
+			forgedSigner{key: victim.PublicKey()},
+			attacker,
+		)},
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	output, err := session.Output("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := gossh.FingerprintSHA256(attacker.PublicKey()); string(output) != want {
+		t.Errorf("sessionCaller() = %s, want the authenticated key %s", output, want)
+	}
+}
@@ -0,0 +1,111 @@
+package commander
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// Version is the build version of the server, reported by the version action
+var Version = "unknown"
+
+// PvcTypes are the pvc types supported by the touch action
+var PvcTypes = []string{"user", "project", "dataset", "raw"}
+
+// Features are the optional behaviors of the actions supported by the server
+var Features = []string{FeatureNamespace}
+
+const (
+	// FeatureNamespace is the support of the --namespace option of the actions
+	FeatureNamespace = "namespace"
+)
+
+// Capabilities describes what the server supports
+type Capabilities struct {
+	Version         string   `json:"version"`
+	ProtocolVersion int      `json:"protocolVersion"`
+	Actions         []string `json:"actions"`
+	PvcTypes        []string `json:"pvcTypes"`
+	Features        []string `json:"features,omitempty"`
+}
+
+// legacyCapabilities is what the servers released before the version action support
+var legacyCapabilities = Capabilities{
+	Version:         "unknown",
+	ProtocolVersion: 0,
+	Actions:         []string{"status", "mount", "unmount", "umount", "touch"},
+	PvcTypes:        []string{"user", "project", "dataset", "raw"},
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Capabilities) SupportsAction(action string) bool {
+	return contains(c.Actions, action)
+}
+
+func (c *Capabilities) SupportsPvcType(pvcType string) bool {
+	return contains(c.PvcTypes, pvcType)
+}
+
+func (c *Capabilities) SupportsFeature(feature string) bool {
+	return contains(c.Features, feature)
+}
+
+func capabilities(w io.Writer, namespace string, args Args) (interface{}, error) {
+	result := Capabilities{
+		Version:         Version,
+		ProtocolVersion: ProtocolVersion,
+		PvcTypes:        PvcTypes,
+		Features:        Features,
+	}
+	for _, action := range serverInstance.Actions {
+		result.Actions = append(result.Actions, action.Names...)
+	}
+	fmt.Fprintf(w, "Version: %s\n", result.Version)
+	fmt.Fprintf(w, "Protocol: %d\n", result.ProtocolVersion)
+	fmt.Fprintf(w, "Actions: %s\n", strings.Join(result.Actions, ", "))
+	fmt.Fprintf(w, "Pvc types: %s\n", strings.Join(result.PvcTypes, ", "))
+	fmt.Fprintf(w, "Features: %s\n", strings.Join(result.Features, ", "))
+	return result, nil
+}
+
+// Capabilities asks the server what it supports once per connection,
+// the servers without the version action are assumed to support the legacy actions.
+func (c *Commander) Capabilities() (*Capabilities, error) {
+	c.mu.RLock()
+	cached := c.capabilities
+	c.mu.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	result := &Capabilities{}
+	response, err := c.Call("version")
+	switch {
+	case ErrorCodeOf(err) == ErrCodeUnsupportedCommand:
+		log.Warnf("[Version] Server does not report its version, assume the legacy server")
+		legacy := legacyCapabilities
+		result = &legacy
+	case err != nil:
+		return nil, err
+	default:
+		err = response.Decode(result)
+		if err != nil {
+			return nil, err
+		}
+	}
+	log.Infof("[Version] Client: %s Server: %s Protocol: %d", c.config.Version, result.Version, result.ProtocolVersion)
+
+	c.mu.Lock()
+	c.capabilities = result
+	c.mu.Unlock()
+	return result, nil
+}
@@ -0,0 +1,82 @@
+package commander
+
+import (
+	"github.com/gliderlabs/ssh"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// startBaselineServer serves like the servers released before the version action,
+// which write the error after the welcome message and exit 75 for every failure
+func startBaselineServer(t *testing.T) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &ssh.Server{Handler: func(s ssh.Session) {
+		io.WriteString(s, welcomeMsg)
+		switch s.Command()[0] {
+		case "status", "mount", "unmount", "umount", "touch":
+			io.WriteString(s, "ok\n")
+		default:
+			io.WriteString(s, "[Error] Unsupported command '"+s.Command()[0]+"'")
+			s.Exit(75)
+		}
+	}}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().(*net.TCPAddr)
+}
+
+func TestCapabilitiesOfBaselineServer(t *testing.T) {
+	addr := startBaselineServer(t)
+	c := &Commander{Remote: "127.0.0.1", Port: uint(addr.Port), Mode: ClientMode, config: Config{InsecureIgnoreHostKey: true}}
+	err := c.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.currentClient().Close()
+	c.transport = &sshTransport{c: c}
+
+	capabilities, err := c.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities() = %v, want the legacy capabilities", err)
+	}
+	if !reflect.DeepEqual(*capabilities, legacyCapabilities) {
+		t.Errorf("Capabilities() = %+v, want %+v", *capabilities, legacyCapabilities)
+	}
+	if !capabilities.SupportsAction("mount") || capabilities.SupportsAction("verify") {
+		t.Errorf("legacy capabilities should support mount only, got %v", capabilities.Actions)
+	}
+}
+
+func TestLegacyResponseError(t *testing.T) {
+	addr := startBaselineServer(t)
+	c := &Commander{Remote: "127.0.0.1", Port: uint(addr.Port), Mode: ClientMode, config: Config{InsecureIgnoreHostKey: true}}
+	err := c.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.currentClient().Close()
+
+	session, err := c.newSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, runErr := session.CombinedOutput("version")
+	session.Close()
+	if ExitStatusOf(toClientError(runErr, "")) != ExitKubernetesAPI {
+		t.Fatalf("baseline exit = %v, want %d", runErr, ExitKubernetesAPI)
+	}
+
+	response := legacyResponse("version", string(output), runErr)
+	if response.Code != ErrCodeUnsupportedCommand {
+		t.Errorf("legacyResponse() code = %s, want %s", response.Code, ErrCodeUnsupportedCommand)
+	}
+	if !strings.Contains(response.Message, "Unsupported command 'version'") {
+		t.Errorf("legacyResponse() message = %q", response.Message)
+	}
+}
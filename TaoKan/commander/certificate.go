@@ -0,0 +1,105 @@
+package commander
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"time"
+)
+
+const (
+	UserCAKeysSecretKey = "user_ca_keys"
+	// sourceAddressOption restricts the client addresses of a certificate, it is checked by the ssh handshake
+	sourceAddressOption = "source-address"
+)
+
+// userCA verifies the client certificates signed by the trusted CA keys.
+// The principals of a certificate are the namespaces its holder may target.
+type userCA struct {
+	keys    authorizedKeys
+	checker *gossh.CertChecker
+}
+
+// loadUserCA loads the CA keys, nil if the certificates are not accepted
+func loadUserCA(config Config) (*userCA, error) {
+	if config.UserCAKeysFile == "" && config.UserCAKeysSecret == "" {
+		return nil, nil
+	}
+	data, err := readKeys("User CA keys", config.UserCAKeysFile, config.UserCAKeysSecret, UserCAKeysSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseAuthorizedKeys(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no user CA keys found")
+	}
+	for fingerprint, key := range keys {
+		log.Infof("[Authorized] User CA %s %s", fingerprint, key.comment)
+	}
+	return &userCA{
+		keys:    keys,
+		checker: &gossh.CertChecker{SupportedCriticalOptions: []string{sourceAddressOption}},
+	}, nil
+}
+
+func (ca *userCA) isAuthority(key gossh.PublicKey) bool {
+	authority, ok := ca.keys[gossh.FingerprintSHA256(key)]
+	return ok && ssh.KeysEqual(key, authority.key)
+}
+
+// verify checks the certificate is a user certificate signed by the CA, in its validity window and bound to namespaces
+func (ca *userCA) verify(cert *gossh.Certificate) error {
+	if cert.CertType != gossh.UserCert {
+		return errors.New("not a user certificate")
+	}
+	if !ca.isAuthority(cert.SignatureKey) {
+		return fmt.Errorf("signed by the unknown authority %s", gossh.FingerprintSHA256(cert.SignatureKey))
+	}
+	if len(cert.ValidPrincipals) == 0 {
+		return errors.New("no principals for the namespaces")
+	}
+	// The principals are the namespaces rather than the ssh user, checked by each action
+	return ca.checker.CheckCert(cert.ValidPrincipals[0], cert)
+}
+
+func (ca *userCA) publicKeyHandler(ctx ssh.Context, cert *gossh.Certificate) bool {
+	err := ca.verify(cert)
+	if err != nil {
+		log.Warnf("[Rejected] User: %s Address: %s Certificate: %s %v", ctx.User(), ctx.RemoteAddr(), cert.KeyId, err)
+		return false
+	}
+	// The handshake rejects the client out of the source addresses of the certificate
+	ctx.Permissions().CriticalOptions = cert.CriticalOptions
+	return true
+}
+
+// certificateCaller identifies the caller by the key id, the principals and the expiry of its certificate
+func certificateCaller(caller Caller, cert *gossh.Certificate) Caller {
+	identity := cert.KeyId
+	if identity == "" {
+		identity = gossh.FingerprintSHA256(cert.Key)
+	}
+	caller.Fingerprint = "cert:" + identity
+	caller.Principals = cert.ValidPrincipals
+	if cert.ValidBefore != gossh.CertTimeInfinity {
+		caller.Expiry = time.Unix(int64(cert.ValidBefore), 0)
+	}
+	return caller
+}
+
+// checkCertificate rejects the caller whose certificate expired since the connection was authenticated,
+// or which is not valid for the namespace. The version handshake is allowed in any namespace.
+func (c Caller) checkCertificate(action Action, namespace string) error {
+	if !c.Expiry.IsZero() && time.Now().After(c.Expiry) {
+		return newError(ErrCodeForbidden, "certificate of %s expired at %s", c.Fingerprint, c.Expiry.Format(time.RFC3339))
+	}
+	if action.Names[0] != "version" && !matchAny(c.Principals, namespace) {
+		return newError(ErrCodeForbidden, "certificate of %s is not valid for namespace %s", c.Fingerprint, namespace)
+	}
+	return nil
+}
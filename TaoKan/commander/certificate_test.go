@@ -0,0 +1,109 @@
+package commander
+
+import (
+	"crypto/rand"
+	gossh "golang.org/x/crypto/ssh"
+	"testing"
+	"time"
+)
+
+func TestUserCAVerify(t *testing.T) {
+	authority := newTestSigner(t)
+	unknown := newTestSigner(t)
+	client := newTestSigner(t)
+	ca := &userCA{
+		keys:    authorizedKeys{gossh.FingerprintSHA256(authority.PublicKey()): {key: authority.PublicKey()}},
+		checker: &gossh.CertChecker{SupportedCriticalOptions: []string{sourceAddressOption}},
+	}
+	now := uint64(time.Now().Unix())
+
+	tests := []struct {
+		name   string
+		modify func(cert *gossh.Certificate)
+		signer gossh.Signer
+		valid  bool
+	}{
+		{"valid", func(cert *gossh.Certificate) {}, authority, true},
+		{"no expiry", func(cert *gossh.Certificate) { cert.ValidBefore = gossh.CertTimeInfinity }, authority, true},
+		{"source address", func(cert *gossh.Certificate) {
+			cert.CriticalOptions = map[string]string{sourceAddressOption: "10.0.0.0/8"}
+		}, authority, true},
+		{"host certificate", func(cert *gossh.Certificate) { cert.CertType = gossh.HostCert }, authority, false},
+		{"unknown authority", func(cert *gossh.Certificate) {}, unknown, false},
+		{"no principals", func(cert *gossh.Certificate) { cert.ValidPrincipals = nil }, authority, false},
+		{"expired", func(cert *gossh.Certificate) { cert.ValidBefore = now - 60 }, authority, false},
+		{"not yet valid", func(cert *gossh.Certificate) { cert.ValidAfter = now + 3600 }, authority, false},
+		{"unsupported critical option", func(cert *gossh.Certificate) { cert.CriticalOptions = map[string]string{"force-command": "sh"} }, authority, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := &gossh.Certificate{
+				Key:             client.PublicKey(),
+				KeyId:           "alice",
+				CertType:        gossh.UserCert,
+				ValidPrincipals: []string{"hub", "tenant-*"},
+				ValidAfter:      now - 60,
+				ValidBefore:     now + 3600,
+			}
+			tt.modify(cert)
+			err := cert.SignCert(rand.Reader, tt.signer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ca.verify(cert)
+			if (err == nil) != tt.valid {
+				t.Errorf("verify() = %v, valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCallerCheckCertificate(t *testing.T) {
+	stat := Action{Names: []string{"stat"}}
+	version := Action{Names: []string{"version", "capabilities"}}
+	principals := []string{"hub", "tenant-*"}
+
+	tests := []struct {
+		name      string
+		caller    Caller
+		action    Action
+		namespace string
+		allowed   bool
+	}{
+		{"without certificate", Caller{}, stat, "other", true},
+		{"principal", Caller{Principals: principals}, stat, "hub", true},
+		{"principal pattern", Caller{Principals: principals}, stat, "tenant-a", true},
+		{"not a principal", Caller{Principals: principals}, stat, "other", false},
+		{"version in any namespace", Caller{Principals: principals}, version, "other", true},
+		{"not expired", Caller{Principals: principals, Expiry: time.Now().Add(time.Hour)}, stat, "hub", true},
+		{"expired", Caller{Principals: principals, Expiry: time.Now().Add(-time.Second)}, stat, "hub", false},
+		{"expired version", Caller{Principals: principals, Expiry: time.Now().Add(-time.Second)}, version, "hub", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.caller.checkCertificate(tt.action, tt.namespace)
+			if (err == nil) != tt.allowed {
+				t.Fatalf("checkCertificate() = %v, allowed %v", err, tt.allowed)
+			}
+			if err != nil && ErrorCodeOf(err) != ErrCodeForbidden {
+				t.Errorf("checkCertificate() code = %s, want %s", ErrorCodeOf(err), ErrCodeForbidden)
+			}
+		})
+	}
+}
+
+func TestCertificateCaller(t *testing.T) {
+	client := newTestSigner(t)
+	cert := &gossh.Certificate{Key: client.PublicKey(), ValidPrincipals: []string{"hub"}, ValidBefore: gossh.CertTimeInfinity}
+	caller := certificateCaller(Caller{Address: "10.0.0.1:2222"}, cert)
+	if caller.Fingerprint != "cert:"+gossh.FingerprintSHA256(client.PublicKey()) || !caller.Expiry.IsZero() {
+		t.Errorf("certificateCaller() = %+v, want the key fingerprint without expiry", caller)
+	}
+
+	cert.KeyId = "alice"
+	cert.ValidBefore = 2000000000
+	caller = certificateCaller(Caller{Address: "10.0.0.1:2222"}, cert)
+	if caller.Fingerprint != "cert:alice" || caller.Expiry.Unix() != 2000000000 || len(caller.Principals) != 1 || caller.Address != "10.0.0.1:2222" {
+		t.Errorf("certificateCaller() = %+v", caller)
+	}
+}
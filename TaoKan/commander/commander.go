@@ -2,15 +2,21 @@ package commander
 
 import (
 	KubernetesAPI "TaoKan/k8s"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/gliderlabs/ssh"
 	"github.com/melbahja/goph"
 	log "github.com/sirupsen/logrus"
 	gossh "golang.org/x/crypto/ssh"
 	"io"
+	"math/rand"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 var KubeConfig string
@@ -29,30 +35,113 @@ const (
 
 const welcomeMsg = "[TaoKan Server]\n"
 
+const (
+	// MaxThrottledRetries is how many times the client retries the action throttled by the server
+	MaxThrottledRetries = 5
+	ThrottledBackoff    = 2 * time.Second
+	MaxThrottledBackoff = time.Minute
+)
+
 var clientInstance *Commander
 var serverInstance *Commander
 
 type Action struct {
-	Names      []string
-	ServerFunc func(w io.Writer, args []string) error
+	Names       []string
+	Params      []Param
+	Description string
+	ServerFunc  func(w io.Writer, namespace string, args Args) (interface{}, error)
+	// Target returns the pvc the action operates on, which is checked against the policy
+	Target func(namespace string, args Args) (*actionTarget, error)
+	// Locked actions hold the lock of the target pvc while running
+	Locked bool
 }
 
+var pvcParam = Param{Name: "pvc", Type: ParamString, Required: true, Description: "Name of the PVC"}
+
+var accessModes = []string{"ReadWriteOnce", "ReadOnlyMany", "ReadWriteMany"}
+
 var actions = []Action{
 	{
-		Names:      []string{"status"},
-		ServerFunc: status,
+		Names: []string{"status"},
+		Params: []Param{
+			{Name: "type", Type: ParamEnum, Values: KubernetesAPI.PvcStatusTypes, Description: "Only the PVCs of the type"},
+			{Name: "name", Type: ParamString, Description: "Only the PVCs whose name matches the glob pattern"},
+			{Name: "in-use", Type: ParamBool, Description: "Only the PVCs used by pods"},
+			{Name: "output", Type: ParamEnum, Values: KubernetesAPI.PvcStatusFormats, Default: "table", Description: "Output format"},
+		},
+		Description: "List the PVCs with the capacity, phase, storage class and the pods using them",
+		ServerFunc:  status,
+	},
+	{
+		Names:       []string{"stat"},
+		Params:      []Param{pvcParam},
+		Description: "Show the details of the PVC",
+		ServerFunc:  statPvc,
+		Target:      pvcTarget,
+	},
+	{
+		Names:       []string{"mount"},
+		Params:      []Param{pvcParam},
+		Description: "Launch the rsync-server pod mounting the PVC",
+		ServerFunc:  mountPvc,
+		Target:      pvcTarget,
+		Locked:      true,
+	},
+	{
+		Names:       []string{"unmount", "umount"},
+		Params:      []Param{pvcParam},
+		Description: "Delete the rsync-server pod of the PVC",
+		ServerFunc:  umountPvc,
+		Target:      pvcTarget,
+		Locked:      true,
 	},
 	{
-		Names:      []string{"mount"},
-		ServerFunc: mountPvc,
+		Names: []string{"touch"},
+		Params: []Param{
+			{Name: "type", Type: ParamEnum, Required: true, Values: PvcTypes, Description: "Type of the PVC"},
+			{Name: "name", Type: ParamString, Required: true, Description: "Name of the user, project or dataset, the PVC name of raw"},
+			{Name: "capacity", Type: ParamQuantity, Required: true, Description: "Requested storage, e.g. 20Gi"},
+			{Name: "accessMode", Type: ParamEnum, Values: accessModes, Description: "Access mode of the raw PVC"},
+		},
+		Description: "Create the PVC, or expand it if it is smaller",
+		ServerFunc:  touchPvc,
+		Target:      touchTarget,
+		Locked:      true,
 	},
 	{
-		Names:      []string{"unmount", "umount"},
-		ServerFunc: umountPvc,
+		Names: []string{"purge"},
+		Params: []Param{
+			pvcParam,
+			{Name: "token", Type: ParamString, Description: "Confirmation token, dry run without it"},
+		},
+		Description: "Delete the PVC created by TaoKan, dry run without the token",
+		ServerFunc:  purgePvc,
+		Target:      pvcTarget,
+		Locked:      true,
 	},
 	{
-		Names:      []string{"touch"},
-		ServerFunc: touchPvc,
+		Names: []string{"verify"},
+		Params: []Param{
+			pvcParam,
+			{Name: "checksum", Type: ParamBool, Description: "Compute the sha256 checksum of the files"},
+		},
+		Description: "List the files of the mounted PVC",
+		ServerFunc:  verifyPvc,
+		Target:      pvcTarget,
+		Locked:      true,
+	},
+	{
+		Names:       []string{"version", "capabilities"},
+		Description: "Show the version, protocol and supported actions of the server",
+		ServerFunc:  capabilities,
+	},
+	{
+		Names: []string{"audit"},
+		Params: []Param{
+			{Name: "limit", Type: ParamInt, Default: strconv.Itoa(DefaultAuditEntries), Description: "Number of the entries"},
+		},
+		Description: "Show the last audit entries, at most limit",
+		ServerFunc:  audit,
 	},
 }
 
@@ -62,107 +151,273 @@ type Commander struct {
 	Mode    Mode
 	Actions []Action
 
+	config Config
+	policy *Policy
+	mu     sync.RWMutex
 	client *goph.Client
+	done   chan struct{}
+	// draining is the connection whose server noticed it is shutting down
+	draining gossh.Conn
+
+	transport    Transport
+	capabilities *Capabilities
 }
 
 type Config struct {
+	Version         string
 	Namespace       string
 	KubeConfig      string
 	Remote          string
 	Port            uint
 	StorageClassRWO string
 	StorageClassRWX string
+
+	AuthorizedKeysFile   string
+	AuthorizedKeysSecret string
+	UserCAKeysFile       string
+	UserCAKeysSecret     string
+	HostKeyFile          string
+	HostKeySecret        string
+	PolicyFile           string
+	PolicyConfigMap      string
+	AuditLogFile         string
+	AuditLogMaxSize      int64
+	AuditLogMaxBackups   int
+	AuditEvents          bool
+	LockWait             time.Duration
+	LockLease            bool
+	HTTPPort             uint
+	TLSCertFile          string
+	TLSKeyFile           string
+	ClientCAFile         string
+	APITokensFile        string
+	APITokensSecret      string
+	AllowedNamespaces    []string
+	CreateNamespace      bool
+	MaxSessions          int
+	MaxMounts            int
+	RateLimit            float64
+	RateBurst            int
+	MaxCapacity          map[string]string
+	StorageBudget        string
+	IdleTimeout          time.Duration
+	ConnLifetime         time.Duration
+
+	KnownHostsFile        string
+	KnownHostsConfigMap   string
+	HostKeyFingerprint    string
+	InsecureIgnoreHostKey bool
+	KeepAliveInterval     time.Duration
+	ConnectTimeout        time.Duration
+	IdentityFile          string
+	CertificateFile       string
+	IdentitySecret        string
+	ActionTimeout         time.Duration
+	ActionTimeouts        map[string]time.Duration
+	Transport             TransportType
+	ServerCAFile          string
+	APITokenFile          string
 }
 
-func serverCommandDispatcher(c *Commander, w io.Writer, commands []string) error {
-	if len(commands) == 0 {
-		return errors.New("[Error] No command provided.")
-	}
-	cmd := commands[0]
-	for _, action := range c.Actions {
+func findAction(actions []Action, cmd string) (Action, bool) {
+	for _, action := range actions {
 		for _, name := range action.Names {
 			if name == cmd {
-				err := action.ServerFunc(w, commands[1:])
-				if err != nil {
-					return err
-				}
-				return nil
+				return action, true
 			}
 		}
 	}
-	return errors.New("Unsupported command '" + cmd + "'")
+	return Action{}, false
 }
 
-func clientCommandDispatcher(c *Commander, command string, args []string) (string, error) {
+func serverCommandDispatcher(c *Commander, caller Caller, w io.Writer, commands []string) (payload interface{}, err error) {
+	if len(commands) == 0 {
+		return nil, newError(ErrCodeInvalidArguments, "No command provided.")
+	}
+	var target *actionTarget
+	namespace := Namespace
+	start := time.Now()
+	defer func() {
+		auditLogger.Record(newAuditEntry(caller, namespace, commands, err, time.Since(start)), target)
+	}()
+
+	err = throttle.allow(caller.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	cmd := commands[0]
+	action, ok := findAction(c.Actions, cmd)
+	if !ok {
+		return nil, newError(ErrCodeUnsupportedCommand, "Unsupported command '%s'", cmd)
+	}
+	namespace, rest, err := targetNamespace(commands[1:])
+	if err != nil {
+		return nil, err
+	}
+	err = caller.checkCertificate(action, namespace)
+	if err != nil {
+		return nil, err
+	}
+	args, err := parseArgs(action.Params, rest)
+	if err != nil {
+		return nil, newError(ErrCodeInvalidArguments, "%s, usage: %s", toError(err).Message, action.usage())
+	}
+	if action.Target != nil {
+		target, err = action.Target(namespace, args)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = c.policy.authorize(caller, action, namespace, target)
+	if err != nil {
+		return nil, err
+	}
+	key := ""
+	if action.Locked && target != nil {
+		key = lockKey(namespace, target.PvcName)
+		holder, err := locks.acquire(key)
+		if err != nil {
+			return nil, err
+		}
+		defer holder.release()
+	}
+	return runAction(action, w, namespace, args, key)
+}
+
+func clientCommandDispatcher(ctx context.Context, c *Commander, command string, args []string) (string, error) {
 	log.Debugf("[Run] Command: `%s`", command)
 	if command == "" {
 		return "", errors.New("[Error] No command provided.")
 	}
+	session, err := c.newSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+	defer cancelOnDone(ctx, session)()
+
 	cmd := fmt.Sprintf("%s %s", command, strings.Join(args, " "))
-	outBytes, err := c.client.Run(cmd)
+	outBytes, err := session.CombinedOutput(cmd)
 	output := string(outBytes)
-	return output, err
+	if err != nil {
+		if ctx.Err() != nil {
+			return output, ctx.Err()
+		}
+		return output, toClientError(err, "")
+	}
+	return output, nil
 }
 
-func StartServer(config Config) error {
-	commander := &Commander{
-		Port:    config.Port,
-		Mode:    ServerMode,
-		Actions: actions,
+func clientCallDispatcher(ctx context.Context, c *Commander, command string, args []string) (*Response, error) {
+	log.Debugf("[Call] Command: `%s`", command)
+	if command == "" {
+		return nil, errors.New("[Error] No command provided.")
+	}
+	session, err := c.newSession()
+	if err != nil {
+		return nil, err
 	}
-	KubeConfig = config.KubeConfig
-	Namespace = config.Namespace
+	defer session.Close()
+	defer cancelOnDone(ctx, session)()
 
-	// Config the specified Storage Class
-	if config.StorageClassRWX != "" || config.StorageClassRWO != "" {
-		k8s := KubernetesAPI.GetInstance(KubeConfig)
-		k8s.SetRwoStorageClass(config.StorageClassRWO)
-		k8s.SetRwxStorageClass(config.StorageClassRWX)
+	err = session.Setenv(ProtocolEnv, string(JSONProtocol))
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd := fmt.Sprintf("%s %s", command, strings.Join(args, " "))
+	err = session.Start(cmd)
+	if err != nil {
+		return nil, err
 	}
+	outBytes, readErr := streamProgress(stdout, progressTrackerOf(ctx))
+	runErr := session.Wait()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if readErr != nil {
+		return nil, readErr
+	}
+	response, err := parseResponse(outBytes)
+	if err != nil {
+		log.Debugf("[Legacy] Server responds in text format")
+		response = legacyResponse(command, string(outBytes), runErr)
+	}
+	return response, response.Err()
+}
 
-	ssh.Handle(func(s ssh.Session) {
-		io.WriteString(s, welcomeMsg)
-		log.Infof("[Receive] Command: `%s`", strings.Join(s.Command(), " "))
-		err := serverCommandDispatcher(commander, s, s.Command())
+// streamProgress logs the progress lines as they arrive and returns the rest of the output.
+// The lines are not limited in length, the result of an action may be a large single line.
+func streamProgress(r io.Reader, tracker *progressTracker) ([]byte, error) {
+	var output bytes.Buffer
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var response Response
+			if json.Unmarshal(line, &response) == nil && response.Status == StatusProgress {
+				var progress Progress
+				if response.Decode(&progress) == nil {
+					log.Infof("[%s] %s: %s", progress.Pvc, progress.Stage, progress.Message)
+					tracker.observe(progress)
+				}
+			} else {
+				output.Write(bytes.TrimSuffix(line, []byte("\n")))
+				output.WriteByte('\n')
+			}
+		}
+		if err == io.EOF {
+			return output.Bytes(), nil
+		}
 		if err != nil {
-			io.WriteString(s, "[Error] "+err.Error())
-			log.Error(err)
-			s.Exit(75)
+			return output.Bytes(), err
 		}
-		log.Infof("[Closed] Command: `%s`", strings.Join(s.Command(), " "))
-	})
-	addr := fmt.Sprintf(":%d", config.Port)
-	go log.Fatal(ssh.ListenAndServe(addr, nil))
-	return nil
+	}
 }
 
+// StartClient connects to the server. The connection is shared by all the actions until Close,
+// every action runs in its own channel of the connection.
 func StartClient(config Config) (*Commander, error) {
+	lock.Lock()
+	defer lock.Unlock()
 	if clientInstance == nil {
-		lock.Lock()
-		defer lock.Unlock()
-		clientInstance = &Commander{
+		commander := &Commander{
 			Port:    config.Port,
 			Remote:  config.Remote,
 			Mode:    ClientMode,
 			Actions: actions,
+			config:  config,
+			done:    make(chan struct{}),
 		}
 		KubeConfig = config.KubeConfig
 		Namespace = config.Namespace
 
-		auth, _ := goph.UseAgent()
-		sshConfig := &goph.Config{
-			User:     "rsync",
-			Addr:     config.Remote,
-			Port:     config.Port,
-			Auth:     auth,
-			Timeout:  goph.DefaultTimeout,
-			Callback: gossh.InsecureIgnoreHostKey(),
-		}
-		client, err := goph.NewConn(sshConfig)
-		if err != nil {
-			log.Fatal(err)
+		switch config.Transport {
+		case HTTPSTransport:
+			transport, err := newHTTPSTransport(config)
+			if err != nil {
+				return nil, err
+			}
+			commander.transport = transport
+		case SSHTransport, "":
+			err := commander.connect()
+			if err != nil {
+				return nil, err
+			}
+
+			interval := config.KeepAliveInterval
+			if interval == 0 {
+				interval = DefaultKeepAliveInterval
+			}
+			go commander.keepAlive(interval)
+			commander.transport = &sshTransport{c: commander}
+		default:
+			return nil, fmt.Errorf("unsupported transport '%s'", config.Transport)
 		}
-		clientInstance.client = client
+		clientInstance = commander
 	}
 	return clientInstance, nil
 }
@@ -171,11 +426,85 @@ func (c *Commander) Close() {
 	if c.Mode == ClientMode {
 		lock.Lock()
 		defer lock.Unlock()
-		c.client.Close()
+		c.transport.Close()
 		clientInstance = nil
 	}
 }
 
+// Run runs the action with the text protocol, which is only served over ssh
 func (c *Commander) Run(cmd string, args ...string) (string, error) {
-	return clientCommandDispatcher(c, cmd, args)
+	if _, ok := c.transport.(*sshTransport); !ok {
+		return "", newError(ErrCodeUnsupportedCommand, "the text protocol is only served over ssh")
+	}
+	var output string
+	err := c.retryThrottled(cmd, func() error {
+		return c.withDeadline(cmd, func(ctx context.Context) error {
+			var err error
+			output, err = clientCommandDispatcher(ctx, c, cmd, args)
+			if c.reconnectIfShuttingDown(err) {
+				output, err = clientCommandDispatcher(ctx, c, cmd, args)
+			}
+			return err
+		})
+	})
+	return output, err
+}
+
+// Call runs the action with the JSON protocol and returns the response envelope
+func (c *Commander) Call(cmd string, args ...string) (*Response, error) {
+	var response *Response
+	err := c.retryThrottled(cmd, func() error {
+		return c.withDeadline(cmd, func(ctx context.Context) error {
+			var err error
+			response, err = c.transport.Call(ctx, cmd, args)
+			if c.reconnectIfShuttingDown(err) {
+				response, err = c.transport.Call(ctx, cmd, args)
+			}
+			return err
+		})
+	})
+	return response, err
+}
+
+// retryThrottled retries the call throttled by the server, it waits for the hint of the server
+// or backs off exponentially if there is no hint
+func (c *Commander) retryThrottled(cmd string, call func() error) error {
+	backoff := ThrottledBackoff
+	for retry := 0; ; retry++ {
+		err := call()
+		if ErrorCodeOf(err) != ErrCodeThrottled || retry >= MaxThrottledRetries {
+			return err
+		}
+
+		delay := backoff
+		var e *Error
+		if errors.As(err, &e) && e.RetryAfter > delay {
+			delay = e.RetryAfter
+		}
+		// Jitter to spread the retries of the clients throttled at the same time
+		delay += time.Duration(rand.Int63n(int64(delay)/4 + 1))
+		log.Warnf("[Throttled] Command: `%s` %v, retry in %v", cmd, err, delay.Round(time.Millisecond))
+		time.Sleep(delay)
+		if backoff < MaxThrottledBackoff {
+			backoff *= 2
+		}
+	}
+}
+
+// reconnectIfShuttingDown reconnects when the server is draining, the new connection
+// reaches another server once the draining one is removed from the service endpoints
+func (c *Commander) reconnectIfShuttingDown(err error) bool {
+	if ErrorCodeOf(err) != ErrCodeShuttingDown {
+		return false
+	}
+	log.Warnf("[Reconnect] Server is shutting down")
+	err = c.transport.Reset()
+	if err != nil {
+		log.Errorf("[Reconnect] %v", err)
+		return false
+	}
+	c.mu.Lock()
+	c.capabilities = nil
+	c.mu.Unlock()
+	return true
 }
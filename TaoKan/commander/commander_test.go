@@ -0,0 +1,48 @@
+package commander
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestStreamProgressLongLine(t *testing.T) {
+	var stream bytes.Buffer
+	w := &sessionWriter{out: io.Discard, session: &stream, protocol: JSONProtocol}
+	w.Progress(Progress{Pvc: "claim-alice", Stage: "Running"})
+	// The manifest of a large pvc is a single line over the buffer of a line scanner
+	entries := make([]ManifestEntry, 200000)
+	for i := range entries {
+		entries[i] = ManifestEntry{Path: strings.Repeat("a", 100), Size: 1}
+	}
+	writeResponse(&stream, newResponse(Manifest{Entries: entries}, nil))
+
+	output, err := streamProgress(&stream, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := parseResponse(output)
+	if err != nil {
+		t.Fatalf("parseResponse() of %d bytes: %v", len(output), err)
+	}
+	var manifest Manifest
+	err = response.Decode(&manifest)
+	if err != nil || len(manifest.Entries) != len(entries) {
+		t.Fatalf("Decode() = %d entries, %v", len(manifest.Entries), err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestStreamProgressReadError(t *testing.T) {
+	_, err := streamProgress(io.MultiReader(strings.NewReader("partial"), failingReader{}), nil)
+	if err == nil {
+		t.Fatal("streamProgress() ignored the read error")
+	}
+}
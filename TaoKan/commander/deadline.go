@@ -0,0 +1,200 @@
+package commander
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultActionTimeout is the deadline of an action on the server
+	DefaultActionTimeout = 30 * time.Minute
+	// DefaultClientActionTimeout is longer than the one of the server, so the server reports the timeout first
+	DefaultClientActionTimeout = 35 * time.Minute
+)
+
+// actionTimeouts are the deadlines of the actions on the server, by action name and "*" for the others
+var actionTimeouts = map[string]time.Duration{"*": DefaultActionTimeout}
+
+// timeoutOf returns the deadline of the action, 0 for no deadline
+func timeoutOf(timeouts map[string]time.Duration, action Action) time.Duration {
+	for _, name := range action.Names {
+		if timeout, ok := timeouts[name]; ok {
+			return timeout
+		}
+	}
+	return timeouts["*"]
+}
+
+// newActionTimeouts merges the default and the per action deadlines
+func newActionTimeouts(timeout time.Duration, perAction map[string]time.Duration) map[string]time.Duration {
+	timeouts := map[string]time.Duration{"*": timeout}
+	for name, value := range perAction {
+		timeouts[name] = value
+	}
+	return timeouts
+}
+
+// stageTracker remembers the last progress stage of the action, and stops writing to the session
+// once the action is abandoned for its deadline
+type stageTracker struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	stage   string
+	expired bool
+}
+
+func (t *stageTracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expired {
+		return len(p), nil
+	}
+	return t.w.Write(p)
+}
+
+func (t *stageTracker) Progress(progress Progress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stage = progress.Stage
+	if reporter, ok := t.w.(progressReporter); ok && !t.expired {
+		reporter.Progress(progress)
+	}
+}
+
+// expire returns the last stage, the later output is discarded
+func (t *stageTracker) expire() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expired = true
+	return t.stage
+}
+
+func timeoutError(action string, timeout time.Duration, stage string) *Error {
+	if stage == "" {
+		return newError(ErrCodeActionTimeout, "%s timed out after %v before any progress", action, timeout)
+	}
+	return newError(ErrCodeActionTimeout, "%s timed out after %v at stage %s", action, timeout, stage)
+}
+
+// runAction runs the action until its deadline. The action cannot be interrupted, so it keeps running
+// in the background after the deadline, and keeps the lock of its pvc until it returns.
+func runAction(action Action, w io.Writer, namespace string, args Args, key string) (interface{}, error) {
+	timeout := timeoutOf(actionTimeouts, action)
+	if timeout <= 0 {
+		return action.ServerFunc(w, namespace, args)
+	}
+
+	type result struct {
+		payload interface{}
+		err     error
+	}
+	tracker := &stageTracker{w: w}
+	done := make(chan result, 1)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		payload, err := action.ServerFunc(tracker, namespace, args)
+		done <- result{payload, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.payload, r.err
+	case <-timer.C:
+	}
+
+	stage := tracker.expire()
+	log.Warnf("[Timeout] %s timed out after %v, stage: %s", action.Names[0], timeout, stage)
+	if action.Locked && key != "" {
+		holder := locks.detach(key)
+		go func() {
+			r := <-done
+			holder.unlock()
+			log.Infof("[Timeout] %s finished after the deadline, err: %v", action.Names[0], r.err)
+		}()
+	}
+	return nil, timeoutError(action.Names[0], timeout, stage)
+}
+
+// progressTrackerKey carries the progressTracker of the call in its context
+type progressTrackerKey struct{}
+
+// progressTracker remembers the last progress stage the client received
+type progressTracker struct {
+	mu    sync.Mutex
+	stage string
+}
+
+func (t *progressTracker) observe(progress Progress) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.stage = progress.Stage
+	t.mu.Unlock()
+}
+
+func progressTrackerOf(ctx context.Context) *progressTracker {
+	tracker, _ := ctx.Value(progressTrackerKey{}).(*progressTracker)
+	return tracker
+}
+
+func (t *progressTracker) lastStage() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.stage
+}
+
+// clientTimeout returns the deadline of the action on the client, 0 for no deadline
+func (c *Commander) clientTimeout(command string) time.Duration {
+	if timeout, ok := c.config.ActionTimeouts[command]; ok {
+		return timeout
+	}
+	return c.config.ActionTimeout
+}
+
+func (t *progressTracker) timeoutError(command string, timeout time.Duration) *Error {
+	e := timeoutError(command, timeout, t.lastStage())
+	e.Message = fmt.Sprintf("%s on the client", e.Message)
+	return e
+}
+
+// withDeadline runs the call of the command until its deadline on the client. The call must give up
+// once the context is done, then the timeout is reported with the last stage the server reached.
+func (c *Commander) withDeadline(command string, call func(ctx context.Context) error) error {
+	tracker := &progressTracker{}
+	ctx := context.WithValue(context.Background(), progressTrackerKey{}, tracker)
+	timeout := c.clientTimeout(command)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	err := call(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		log.Warnf("[Timeout] Command: `%s` timed out after %v", command, timeout)
+		return tracker.timeoutError(command, timeout)
+	}
+	return err
+}
+
+// cancelOnDone closes the session when the context is done, the returned func stops watching
+func cancelOnDone(ctx context.Context, session io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
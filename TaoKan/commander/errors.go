@@ -0,0 +1,214 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"errors"
+	"fmt"
+	gossh "golang.org/x/crypto/ssh"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"strings"
+	"time"
+)
+
+type ErrorCode string
+
+const (
+	ErrCodeInternal           ErrorCode = "Internal"
+	ErrCodeInvalidArguments   ErrorCode = "InvalidArguments"
+	ErrCodePvcNotFound        ErrorCode = "PvcNotFound"
+	ErrCodeQuotaExceeded      ErrorCode = "QuotaExceeded"
+	ErrCodePodLaunchTimeout   ErrorCode = "PodLaunchTimeout"
+	ErrCodeUnsupportedCommand ErrorCode = "UnsupportedCommand"
+	ErrCodeKubernetesAPI      ErrorCode = "KubernetesAPI"
+	ErrCodeCannotExpand       ErrorCode = "CannotExpand"
+	ErrCodeResizeTimeout      ErrorCode = "ResizeTimeout"
+	ErrCodeForbidden          ErrorCode = "Forbidden"
+	ErrCodePvcInUse           ErrorCode = "PvcInUse"
+	ErrCodePvcNotMounted      ErrorCode = "PvcNotMounted"
+	ErrCodeBusy               ErrorCode = "Busy"
+	ErrCodeShuttingDown       ErrorCode = "ShuttingDown"
+	ErrCodeThrottled          ErrorCode = "Throttled"
+	ErrCodeActionTimeout      ErrorCode = "ActionTimeout"
+)
+
+// Exit statuses of the server session, one for each error code
+//
+//	 0  success
+//	64  invalid arguments
+//	66  pvc not found
+//	69  unsupported command
+//	70  internal error
+//	75  kubernetes api failure, retry later
+//	77  forbidden
+//	80  quota exceeded
+//	81  pod launch timeout, retry later
+//	82  cannot expand the existing pvc
+//	83  pvc resize timeout, retry later
+//	84  pvc is in use
+//	85  pvc is not mounted by rsync-server, retry after mounted
+//	86  pvc is locked by another action, retry later
+//	87  server is shutting down, retry later
+//	88  throttled by the server limits, retry later
+//	89  action timed out, retry later
+const (
+	ExitSuccess            = 0
+	ExitInvalidArguments   = 64
+	ExitPvcNotFound        = 66
+	ExitUnsupportedCommand = 69
+	ExitInternal           = 70
+	ExitForbidden          = 77
+	ExitKubernetesAPI      = 75
+	ExitQuotaExceeded      = 80
+	ExitPodLaunchTimeout   = 81
+	ExitCannotExpand       = 82
+	ExitResizeTimeout      = 83
+	ExitPvcInUse           = 84
+	ExitPvcNotMounted      = 85
+	ExitBusy               = 86
+	ExitShuttingDown       = 87
+	ExitThrottled          = 88
+	ExitActionTimeout      = 89
+)
+
+// ExitDiverged is the exit status of the client verify when the manifests of the pvcs differ,
+// the failures of verify exit with the statuses of their errors
+const ExitDiverged = 65
+
+var exitStatuses = map[ErrorCode]int{
+	ErrCodeInternal:           ExitInternal,
+	ErrCodeInvalidArguments:   ExitInvalidArguments,
+	ErrCodePvcNotFound:        ExitPvcNotFound,
+	ErrCodeQuotaExceeded:      ExitQuotaExceeded,
+	ErrCodePodLaunchTimeout:   ExitPodLaunchTimeout,
+	ErrCodeUnsupportedCommand: ExitUnsupportedCommand,
+	ErrCodeKubernetesAPI:      ExitKubernetesAPI,
+	ErrCodeCannotExpand:       ExitCannotExpand,
+	ErrCodeResizeTimeout:      ExitResizeTimeout,
+	ErrCodeForbidden:          ExitForbidden,
+	ErrCodePvcInUse:           ExitPvcInUse,
+	ErrCodePvcNotMounted:      ExitPvcNotMounted,
+	ErrCodeBusy:               ExitBusy,
+	ErrCodeShuttingDown:       ExitShuttingDown,
+	ErrCodeThrottled:          ExitThrottled,
+	ErrCodeActionTimeout:      ExitActionTimeout,
+}
+
+// retryableCodes are the errors which may succeed when the client retries later
+var retryableCodes = map[ErrorCode]bool{
+	ErrCodePodLaunchTimeout: true,
+	ErrCodeKubernetesAPI:    true,
+	ErrCodeResizeTimeout:    true,
+	ErrCodePvcNotMounted:    true,
+	ErrCodeBusy:             true,
+	ErrCodeShuttingDown:     true,
+	ErrCodeThrottled:        true,
+	ErrCodeActionTimeout:    true,
+}
+
+// Error is the typed error of the commander actions
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// RetryAfter is how long the client should wait before retrying the throttled action
+	RetryAfter time.Duration
+}
+
+func newError(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("[%s] %s", e.Code, e.Message)
+}
+
+func (e *Error) ExitStatus() int {
+	if status, ok := exitStatuses[e.Code]; ok {
+		return status
+	}
+	return ExitInternal
+}
+
+func (e *Error) Retryable() bool {
+	return retryableCodes[e.Code]
+}
+
+// IsRetryable reports whether the failed action is worth to retry later
+func IsRetryable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Retryable()
+	}
+	return false
+}
+
+// ExitStatusOf returns the exit status of the failed action, ExitSuccess if err is nil
+func ExitStatusOf(err error) int {
+	if err == nil {
+		return ExitSuccess
+	}
+	return toError(err).ExitStatus()
+}
+
+// ErrorCodeOf returns the error code of err, ErrCodeInternal for untyped errors
+func ErrorCodeOf(err error) ErrorCode {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ErrCodeInternal
+}
+
+// toError classifies an error returned by the actions into the error taxonomy
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	if errors.Is(err, KubernetesAPI.ErrPodLaunchTimeout) {
+		return &Error{Code: ErrCodePodLaunchTimeout, Message: err.Error()}
+	}
+	if errors.Is(err, KubernetesAPI.ErrCannotExpand) {
+		return &Error{Code: ErrCodeCannotExpand, Message: err.Error()}
+	}
+	if errors.Is(err, KubernetesAPI.ErrPvcResizeTimeout) {
+		return &Error{Code: ErrCodeResizeTimeout, Message: err.Error()}
+	}
+
+	var statusErr *k8sErrors.StatusError
+	if errors.As(err, &statusErr) {
+		details := statusErr.ErrStatus.Details
+		switch {
+		case k8sErrors.IsNotFound(err) && details != nil && details.Kind == "persistentvolumeclaims":
+			return &Error{Code: ErrCodePvcNotFound, Message: err.Error()}
+		case k8sErrors.IsForbidden(err) && strings.Contains(err.Error(), "exceeded quota"):
+			return &Error{Code: ErrCodeQuotaExceeded, Message: err.Error()}
+		case k8sErrors.IsInvalid(err):
+			return &Error{Code: ErrCodeInvalidArguments, Message: err.Error()}
+		default:
+			return &Error{Code: ErrCodeKubernetesAPI, Message: err.Error()}
+		}
+	}
+	return &Error{Code: ErrCodeInternal, Message: err.Error()}
+}
+
+// errorFromExitStatus restores the typed error from the exit status of a session
+func errorFromExitStatus(status int, message string) *Error {
+	for code, exitStatus := range exitStatuses {
+		if exitStatus == status {
+			return &Error{Code: code, Message: message}
+		}
+	}
+	return &Error{Code: ErrCodeInternal, Message: fmt.Sprintf("exit status %d: %s", status, message)}
+}
+
+// toClientError converts the error of a remote command into the typed error
+func toClientError(err error, message string) *Error {
+	var exitErr *gossh.ExitError
+	if errors.As(err, &exitErr) {
+		if message == "" {
+			message = exitErr.Msg()
+		}
+		return errorFromExitStatus(exitErr.ExitStatus(), message)
+	}
+	return &Error{Code: ErrCodeInternal, Message: err.Error()}
+}
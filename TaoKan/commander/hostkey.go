@@ -0,0 +1,144 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	DefaultHostKeySecret       = "taokan-host-key"
+	HostKeySecretKey           = "ssh_host_rsa_key"
+	DefaultKnownHostsConfigMap = "taokan-known-hosts"
+	KnownHostsConfigMapKey     = "known_hosts"
+)
+
+// loadHostKey returns the PEM encoded host key of the server. The key stored in the secret is
+// generated and persisted on first start, so the server keeps the same identity across restarts.
+func loadHostKey(config Config) ([]byte, error) {
+	if config.HostKeyFile != "" {
+		log.Infof("[Load] Host key from file %s", config.HostKeyFile)
+		return os.ReadFile(config.HostKeyFile)
+	}
+	if config.HostKeySecret == "" {
+		return nil, errors.New("no host key configured")
+	}
+
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	secret, err := k8s.GetSecret(Namespace, config.HostKeySecret)
+	if err == nil {
+		hostKey, ok := secret.Data[HostKeySecretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key '%s'", config.HostKeySecret, HostKeySecretKey)
+		}
+		log.Infof("[Load] Host key from secret %s/%s", Namespace, config.HostKeySecret)
+		return hostKey, nil
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	log.Warnf("[Generate] Host key secret %s/%s not found, create a new one", Namespace, config.HostKeySecret)
+	key, err := rsa.GenerateKey(rand.Reader, 3072)
+	if err != nil {
+		return nil, err
+	}
+	hostKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+	err = k8s.CreateSecret(Namespace, config.HostKeySecret, map[string][]byte{HostKeySecretKey: hostKey})
+	if err != nil {
+		return nil, err
+	}
+	return hostKey, nil
+}
+
+func hostKeyCallback(config Config) (gossh.HostKeyCallback, error) {
+	switch {
+	case config.InsecureIgnoreHostKey:
+		log.Warnf("[Insecure] Host key verification is disabled")
+		return gossh.InsecureIgnoreHostKey(), nil
+	case config.HostKeyFingerprint != "":
+		return pinnedHostKeyCallback(config.HostKeyFingerprint), nil
+	case config.KnownHostsFile != "":
+		return knownhosts.New(config.KnownHostsFile)
+	case config.KnownHostsConfigMap != "":
+		return configMapHostKeyCallback(config.KnownHostsConfigMap), nil
+	}
+	return nil, errors.New("no host key verification configured")
+}
+
+func pinnedHostKeyCallback(fingerprint string) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		actual := gossh.FingerprintSHA256(key)
+		if actual != fingerprint {
+			return fmt.Errorf("host key mismatch for %s: expected %s, got %s", hostname, fingerprint, actual)
+		}
+		return nil
+	}
+}
+
+// configMapHostKeyCallback verifies the host key against the known_hosts stored in a ConfigMap.
+// Unknown hosts are trusted on first use and recorded into the ConfigMap.
+func configMapHostKeyCallback(name string) gossh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key gossh.PublicKey) error {
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		configMap, err := k8s.GetConfigMap(Namespace, name)
+		if err != nil && !k8sErrors.IsNotFound(err) {
+			return err
+		}
+
+		knownHosts := ""
+		if err == nil {
+			knownHosts = configMap.Data[KnownHostsConfigMapKey]
+		}
+		if knownHosts != "" {
+			err = checkKnownHosts(knownHosts, hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+				return err
+			}
+		}
+
+		line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+		log.Warnf("[Trust] Host %s key %s on first use", hostname, gossh.FingerprintSHA256(key))
+		knownHosts = strings.TrimRight(knownHosts, "\n")
+		if knownHosts != "" {
+			knownHosts += "\n"
+		}
+		return k8s.ApplyConfigMap(Namespace, name, map[string]string{
+			KnownHostsConfigMapKey: knownHosts + line + "\n",
+		})
+	}
+}
+
+func checkKnownHosts(knownHosts string, hostname string, remote net.Addr, key gossh.PublicKey) error {
+	f, err := os.CreateTemp("", "known_hosts")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(knownHosts)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return err
+	}
+	return callback(hostname, remote, key)
+}
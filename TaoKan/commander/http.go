@@ -0,0 +1,294 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	apiActionsPath    = "/api/v1/actions/"
+	ndjsonContentType = "application/x-ndjson"
+)
+
+//go:embed openapi.yaml
+var OpenAPISpec []byte
+
+// ActionRequest is the body of the action calls over http, the params are given by name
+type ActionRequest struct {
+	Args   []string          `json:"args"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// apiTokens maps the bearer tokens to the names of their holders
+type apiTokens map[string]string
+
+// parseAPITokens parses the lines of "<name>:<token>", the empty lines and the comments are ignored
+func parseAPITokens(data []byte, tokens apiTokens) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || fields[0] == "" || fields[1] == "" {
+			return fmt.Errorf("invalid token line, expect '<name>:<token>'")
+		}
+		err := tokens.add(fields[0], fields[1])
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// add registers the token of the holder, a token shared by two holders is rejected for the audit
+// would attribute the calls of one to the other
+func (t apiTokens) add(name string, token string) error {
+	if holder, ok := t[token]; ok {
+		return fmt.Errorf("token of %s is the same as the token of %s", name, holder)
+	}
+	t[token] = name
+	return nil
+}
+
+// loadAPITokens loads the tokens from the file and the secret, every key of the secret is the name of a token holder
+func loadAPITokens(config Config) (apiTokens, error) {
+	tokens := apiTokens{}
+	if config.APITokensFile != "" {
+		log.Infof("[Load] API tokens from file %s", config.APITokensFile)
+		content, err := os.ReadFile(config.APITokensFile)
+		if err != nil {
+			return nil, err
+		}
+		err = parseAPITokens(content, tokens)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.APITokensSecret != "" {
+		log.Infof("[Load] API tokens from secret %s/%s", Namespace, config.APITokensSecret)
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		secret, err := k8s.GetSecret(Namespace, config.APITokensSecret)
+		if err != nil {
+			return nil, err
+		}
+		for name, token := range secret.Data {
+			err = tokens.add(name, strings.TrimSpace(string(token)))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range tokens {
+		log.Infof("[Authorized] token:%s", name)
+	}
+	return tokens, nil
+}
+
+func (t apiTokens) lookup(token string) (string, bool) {
+	for candidate, name := range t {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// httpAPI serves the actions over https, the callers are authenticated by client certificates or bearer tokens
+type httpAPI struct {
+	server *Server
+	tokens apiTokens
+	http   *http.Server
+}
+
+func newHTTPAPI(s *Server, config Config) (*httpAPI, error) {
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return nil, errors.New("the http api requires the tls certificate and key")
+	}
+	tokens, err := loadAPITokens(config)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.ClientCAFile != "" {
+		log.Infof("[Load] Client CA from file %s", config.ClientCAFile)
+		content, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	if len(tokens) == 0 && tlsConfig.ClientCAs == nil {
+		return nil, errors.New("the http api requires the api tokens or the client CA")
+	}
+
+	api := &httpAPI{server: s, tokens: tokens}
+	api.http = &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.HTTPPort),
+		Handler:           httpHandler(api),
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	return api, nil
+}
+
+func httpHandler(api *httpAPI) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(apiActionsPath, api.handleAction)
+	mux.HandleFunc("/api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(OpenAPISpec)
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return mux
+}
+
+// authenticate identifies the caller by the verified client certificate or the bearer token
+func (api *httpAPI) authenticate(r *http.Request) (Caller, bool) {
+	caller := Caller{Address: r.RemoteAddr}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		caller.Fingerprint = "cert:" + r.TLS.VerifiedChains[0][0].Subject.CommonName
+		return caller, true
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return caller, false
+	}
+	if name, ok := api.tokens.lookup(token); ok {
+		caller.Fingerprint = "token:" + name
+		return caller, true
+	}
+	return caller, false
+}
+
+// httpStatuses are the http statuses of the error codes, the others are internal server errors
+var httpStatuses = map[ErrorCode]int{
+	ErrCodeInvalidArguments:   http.StatusBadRequest,
+	ErrCodePvcNotFound:        http.StatusNotFound,
+	ErrCodeUnsupportedCommand: http.StatusNotFound,
+	ErrCodeForbidden:          http.StatusForbidden,
+	ErrCodeQuotaExceeded:      http.StatusForbidden,
+	ErrCodePvcInUse:           http.StatusConflict,
+	ErrCodeBusy:               http.StatusConflict,
+	ErrCodeCannotExpand:       http.StatusConflict,
+	ErrCodePvcNotMounted:      http.StatusConflict,
+	ErrCodeShuttingDown:       http.StatusServiceUnavailable,
+	ErrCodeThrottled:          http.StatusTooManyRequests,
+	ErrCodePodLaunchTimeout:   http.StatusGatewayTimeout,
+	ErrCodeResizeTimeout:      http.StatusGatewayTimeout,
+	ErrCodeActionTimeout:      http.StatusGatewayTimeout,
+	ErrCodeKubernetesAPI:      http.StatusBadGateway,
+}
+
+func httpStatus(response *Response) int {
+	if response.Status != StatusError {
+		return http.StatusOK
+	}
+	if status, ok := httpStatuses[response.Code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// httpWriter streams the progress as json lines, the status is sent with the first line
+type httpWriter struct {
+	w           http.ResponseWriter
+	wroteHeader bool
+}
+
+func (h *httpWriter) Write(p []byte) (int, error) {
+	// Free text output is only for the legacy ssh clients
+	return len(p), nil
+}
+
+func (h *httpWriter) Progress(progress Progress) {
+	response := newResponse(progress, nil)
+	response.Status = StatusProgress
+	h.writeResponse(response)
+	if flusher, ok := h.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (h *httpWriter) writeResponse(response *Response) {
+	if !h.wroteHeader {
+		h.w.Header().Set("Content-Type", ndjsonContentType)
+		h.w.WriteHeader(httpStatus(response))
+		h.wroteHeader = true
+	}
+	writeResponse(h.w, response)
+}
+
+func (api *httpAPI) handleAction(w http.ResponseWriter, r *http.Request) {
+	writer := &httpWriter{w: w}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := api.authenticate(r)
+	if !ok {
+		log.Warnf("[Unauthorized] Address: %s", caller.Address)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	action := strings.TrimPrefix(r.URL.Path, apiActionsPath)
+	var request ActionRequest
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024*1024)).Decode(&request)
+		if err != nil {
+			writer.writeResponse(newResponse(nil, newError(ErrCodeInvalidArguments, "invalid request body: %v", err)))
+			return
+		}
+	}
+	commands := append([]string{action}, request.Args...)
+	for name, value := range request.Params {
+		commands = append(commands, fmt.Sprintf("--%s=%s", name, value))
+	}
+	log.Infof("[Receive] Key: %s Command: `%s`", caller.Fingerprint, strings.Join(commands, " "))
+
+	var payload interface{}
+	closeSession, err := api.server.openSession()
+	if err == nil {
+		err = api.server.begin()
+		if err == nil {
+			payload, err = serverCommandDispatcher(api.server.commander, caller, writer, commands)
+			api.server.inflight.Done()
+		}
+		closeSession()
+	}
+	response := newResponse(payload, err)
+	if response.RetryAfterMs > 0 && !writer.wroteHeader {
+		w.Header().Set("Retry-After", strconv.FormatInt((response.RetryAfterMs+999)/1000, 10))
+	}
+	writer.writeResponse(response)
+	if err != nil {
+		log.Error(err)
+	}
+	log.Infof("[Closed] Key: %s Command: `%s` Status: %d", caller.Fingerprint, strings.Join(commands, " "), httpStatus(response))
+}
@@ -0,0 +1,33 @@
+package commander
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAPITokens(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    apiTokens
+		wantErr bool
+	}{
+		{"tokens", "ci:abc\n# comment\n\nbackup:def\n", apiTokens{"abc": "ci", "def": "backup"}, false},
+		{"token with colon", "ci:a:b\n", apiTokens{"a:b": "ci"}, false},
+		{"invalid line", "ci\n", nil, true},
+		{"empty token", "ci:\n", nil, true},
+		{"duplicated token", "ci:abc\nbackup:abc\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens := apiTokens{}
+			err := parseAPITokens([]byte(tt.data), tokens)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAPITokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(tokens, tt.want) {
+				t.Errorf("parseAPITokens() = %v, want %v", tokens, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	IdentitySecretKey    = "ssh-privatekey"
+	CertificateSecretKey = "ssh-certificate"
+)
+
+// certificateNextTo finds the certificate of the key file, named by ssh-keygen or by the key of the mounted secret
+func certificateNextTo(identityFile string) string {
+	for _, certFile := range []string{identityFile + "-cert.pub", filepath.Join(filepath.Dir(identityFile), CertificateSecretKey)} {
+		if _, err := os.Stat(certFile); err == nil {
+			return certFile
+		}
+	}
+	return ""
+}
+
+// loadIdentity loads the client key and its optional certificate from the files or the secret in the client namespace,
+// nil if no identity configured. It is loaded on every connect, so a renewed certificate is picked up by reconnecting.
+func loadIdentity(config Config) (gossh.Signer, error) {
+	var keyData, certData []byte
+	switch {
+	case config.IdentityFile != "":
+		content, err := os.ReadFile(config.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		keyData = content
+		certFile := config.CertificateFile
+		if certFile == "" {
+			certFile = certificateNextTo(config.IdentityFile)
+		}
+		if certFile != "" {
+			certData, err = os.ReadFile(certFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case config.IdentitySecret != "":
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		secret, err := k8s.GetSecret(Namespace, config.IdentitySecret)
+		if err != nil {
+			return nil, err
+		}
+		content, ok := secret.Data[IdentitySecretKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key '%s'", config.IdentitySecret, IdentitySecretKey)
+		}
+		keyData = content
+		certData = secret.Data[CertificateSecretKey]
+	default:
+		return nil, nil
+	}
+
+	signer, err := gossh.ParsePrivateKey(keyData)
+	if err != nil {
+		return nil, err
+	}
+	if len(certData) == 0 {
+		log.Debugf("[Identity] Key %s", gossh.FingerprintSHA256(signer.PublicKey()))
+		return signer, nil
+	}
+	key, _, _, _, err := gossh.ParseAuthorizedKey(certData)
+	if err != nil {
+		return nil, err
+	}
+	cert, ok := key.(*gossh.Certificate)
+	if !ok {
+		return nil, errors.New("the certificate of the identity is a plain public key")
+	}
+	if cert.ValidBefore != gossh.CertTimeInfinity && time.Now().After(time.Unix(int64(cert.ValidBefore), 0)) {
+		log.Warnf("[Identity] Certificate %s expired at %s", cert.KeyId, time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	}
+	log.Debugf("[Identity] Certificate %s, principals: %s", cert.KeyId, strings.Join(cert.ValidPrincipals, ", "))
+	return gossh.NewCertSigner(cert, signer)
+}
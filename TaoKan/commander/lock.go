@@ -0,0 +1,223 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultLockWait = time.Minute
+	// LockLeaseDuration is how long a lease is valid, the lease of a crashed server is taken over after it expired.
+	// The held lease is renewed every third of the duration.
+	LockLeaseDuration = 10 * time.Minute
+	lockLeasePrefix   = "taokan-lock-"
+	lockLeasePoll     = 2 * time.Second
+)
+
+// pvcLocks serializes the actions on the same pvc. The lock is in memory, and optionally backed by
+// a coordination.k8s.io Lease to serialize the actions across the server replicas.
+type pvcLocks struct {
+	wait     time.Duration
+	lease    bool
+	identity string
+
+	mu         sync.Mutex
+	semaphores map[string]chan struct{}
+	// refs counts the holder and the waiters of each semaphore, which is removed when it drops to 0
+	refs    map[string]int
+	holders map[string]*lockHolder
+}
+
+// lockHolder releases the lock of the pvc, unless the action detached it to release later by itself
+type lockHolder struct {
+	pvc   string
+	sem   chan struct{}
+	locks *pvcLocks
+
+	once     sync.Once
+	detached bool
+	// renewed stops renewing the lease of the lock
+	renewed chan struct{}
+}
+
+var locks = newPvcLocks(DefaultLockWait, false)
+
+// lockKey is the key of the lock of the pvc in the namespace
+func lockKey(namespace string, pvc string) string {
+	return namespace + "/" + pvc
+}
+
+// leaseName is the name of the lease of the lock key, the leases are in the server namespace
+func leaseName(key string) string {
+	return lockLeasePrefix + strings.ReplaceAll(key, "/", ".")
+}
+
+func newPvcLocks(wait time.Duration, lease bool) *pvcLocks {
+	identity, _ := os.Hostname()
+	return &pvcLocks{
+		wait:       wait,
+		lease:      lease,
+		identity:   identity,
+		semaphores: map[string]chan struct{}{},
+		refs:       map[string]int{},
+		holders:    map[string]*lockHolder{},
+	}
+}
+
+// ref returns the semaphore of the pvc for the caller to wait on, it must be unref'ed after unlocked or given up
+func (l *pvcLocks) ref(pvc string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sem, ok := l.semaphores[pvc]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		l.semaphores[pvc] = sem
+	}
+	l.refs[pvc]++
+	return sem
+}
+
+func (l *pvcLocks) unref(pvc string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refs[pvc]--
+	if l.refs[pvc] <= 0 {
+		delete(l.refs, pvc)
+		delete(l.semaphores, pvc)
+	}
+}
+
+// acquire locks the pvc, it waits up to the lock wait and fails with busy if the pvc is still locked
+func (l *pvcLocks) acquire(pvc string) (*lockHolder, error) {
+	deadline := time.Now().Add(l.wait)
+	sem := l.ref(pvc)
+	select {
+	case sem <- struct{}{}:
+	default:
+		if l.wait <= 0 {
+			l.unref(pvc)
+			return nil, newError(ErrCodeBusy, "pvc %s is busy", pvc)
+		}
+		log.Infof("[Lock] Wait for pvc %s", pvc)
+		timer := time.NewTimer(l.wait)
+		defer timer.Stop()
+		select {
+		case sem <- struct{}{}:
+		case <-timer.C:
+			l.unref(pvc)
+			return nil, newError(ErrCodeBusy, "pvc %s is busy after waiting %v", pvc, l.wait)
+		}
+	}
+
+	if l.lease {
+		err := l.acquireLease(pvc, deadline)
+		if err != nil {
+			<-sem
+			l.unref(pvc)
+			return nil, err
+		}
+	}
+
+	holder := &lockHolder{pvc: pvc, sem: sem, locks: l}
+	if l.lease {
+		holder.renewed = make(chan struct{})
+		go l.renewLease(pvc, holder.renewed)
+	}
+	l.mu.Lock()
+	l.holders[pvc] = holder
+	l.mu.Unlock()
+	log.Debugf("[Lock] Pvc %s locked", pvc)
+	return holder, nil
+}
+
+func (l *pvcLocks) acquireLease(pvc string, deadline time.Time) error {
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	for {
+		err := k8s.AcquireLease(Namespace, leaseName(pvc), l.identity, LockLeaseDuration)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, KubernetesAPI.ErrLeaseHeld) {
+			return err
+		}
+		if time.Now().Add(lockLeasePoll).After(deadline) {
+			return newError(ErrCodeBusy, "pvc %s is busy: %v", pvc, err)
+		}
+		time.Sleep(lockLeasePoll)
+	}
+}
+
+// renewLease keeps the lease of the pvc until the lock is unlocked, so the actions running longer than
+// the lease duration are not taken over by another replica
+func (l *pvcLocks) renewLease(pvc string, stop <-chan struct{}) {
+	ticker := time.NewTicker(LockLeaseDuration / 3)
+	defer ticker.Stop()
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			err := k8s.RenewLease(Namespace, leaseName(pvc), l.identity)
+			if errors.Is(err, KubernetesAPI.ErrLeaseHeld) {
+				log.Errorf("[Lock] Lease of pvc %s is lost: %v", pvc, err)
+				return
+			}
+			if err != nil {
+				log.Warnf("[Lock] Renew lease of pvc %s failed: %v", pvc, err)
+			}
+		}
+	}
+}
+
+// detach hands over the lock of the pvc to the caller, which must unlock it when done.
+// It is called by the locked actions which keep working on the pvc after they returned.
+func (l *pvcLocks) detach(pvc string) *lockHolder {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	holder := l.holders[pvc]
+	if holder != nil {
+		holder.detached = true
+	}
+	return holder
+}
+
+// release is deferred by the dispatcher, it leaves the detached lock to its new owner
+func (h *lockHolder) release() {
+	h.locks.mu.Lock()
+	detached := h.detached
+	h.locks.mu.Unlock()
+	if !detached {
+		h.unlock()
+	}
+}
+
+func (h *lockHolder) unlock() {
+	if h == nil {
+		return
+	}
+	h.once.Do(func() {
+		l := h.locks
+		if l.lease {
+			close(h.renewed)
+			k8s := KubernetesAPI.GetInstance(KubeConfig)
+			err := k8s.ReleaseLease(Namespace, leaseName(h.pvc), l.identity)
+			if err != nil {
+				log.Warnf("[Lock] Release lease of pvc %s failed: %v", h.pvc, err)
+			}
+		}
+		l.mu.Lock()
+		if l.holders[h.pvc] == h {
+			delete(l.holders, h.pvc)
+		}
+		l.mu.Unlock()
+		<-h.sem
+		l.unref(h.pvc)
+		log.Debugf("[Lock] Pvc %s unlocked", h.pvc)
+	})
+}
@@ -0,0 +1,59 @@
+package commander
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPvcLocksRemovesReleasedSemaphores(t *testing.T) {
+	l := newPvcLocks(time.Second, false)
+	holder, err := l.acquire("hub/claim-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan *lockHolder)
+	go func() {
+		waiter, err := l.acquire("hub/claim-alice")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- waiter
+	}()
+	for {
+		l.mu.Lock()
+		refs := l.refs["hub/claim-alice"]
+		l.mu.Unlock()
+		if refs == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// The semaphore is kept for the waiter
+	holder.release()
+	waiter := <-acquired
+	if len(l.semaphores) != 1 {
+		t.Fatalf("semaphores = %d, want 1 for the waiter", len(l.semaphores))
+	}
+	waiter.release()
+	if len(l.semaphores) != 0 || len(l.refs) != 0 || len(l.holders) != 0 {
+		t.Fatalf("semaphores = %d, refs = %d, holders = %d after released", len(l.semaphores), len(l.refs), len(l.holders))
+	}
+}
+
+func TestPvcLocksBusy(t *testing.T) {
+	l := newPvcLocks(0, false)
+	holder, err := l.acquire("hub/claim-alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.acquire("hub/claim-alice")
+	if ErrorCodeOf(err) != ErrCodeBusy {
+		t.Fatalf("acquire() = %v, want %s", err, ErrCodeBusy)
+	}
+	holder.release()
+	if len(l.semaphores) != 0 || len(l.refs) != 0 {
+		t.Fatalf("semaphores = %d, refs = %d after released", len(l.semaphores), len(l.refs))
+	}
+}
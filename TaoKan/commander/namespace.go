@@ -0,0 +1,48 @@
+package commander
+
+import (
+	"strings"
+)
+
+// allowedNamespaces are the namespaces the actions may target, only the server namespace if empty
+var allowedNamespaces []string
+
+// createNamespace creates the target namespace of touch if it does not exist
+var createNamespace bool
+
+// namespaceAllowed checks the namespace against the allowlist of the server
+func namespaceAllowed(namespace string) bool {
+	if namespace == Namespace {
+		return true
+	}
+	return len(allowedNamespaces) > 0 && matchAny(allowedNamespaces, namespace)
+}
+
+// targetNamespace takes the "--namespace <ns>", "--namespace=<ns>" or "-n <ns>" option out of the arguments,
+// the actions target the server namespace without the option
+func targetNamespace(args []string) (string, []string, error) {
+	namespace := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--namespace" || arg == "-n":
+			if i+1 >= len(args) {
+				return Namespace, nil, newError(ErrCodeInvalidArguments, "%s requires a value", arg)
+			}
+			i++
+			namespace = args[i]
+		case strings.HasPrefix(arg, "--namespace="):
+			namespace = strings.TrimPrefix(arg, "--namespace=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if namespace == "" {
+		return Namespace, rest, nil
+	}
+	if !namespaceAllowed(namespace) {
+		return Namespace, nil, newError(ErrCodeForbidden, "namespace %s is not allowed", namespace)
+	}
+	return namespace, rest, nil
+}
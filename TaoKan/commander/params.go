@@ -0,0 +1,228 @@
+package commander
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"strconv"
+	"strings"
+)
+
+type ParamType string
+
+const (
+	ParamString   ParamType = "string"
+	ParamInt      ParamType = "int"
+	ParamQuantity ParamType = "quantity"
+	ParamEnum     ParamType = "enum"
+	// ParamBool is a flag without value, e.g. "--checksum"
+	ParamBool ParamType = "bool"
+)
+
+// Param declares an argument of the action. The arguments are given by position in the declared order,
+// or by name as "--name value" or "--name=value". The bool params are only given by name.
+type Param struct {
+	Name        string
+	Type        ParamType
+	Required    bool
+	Default     string
+	Description string
+	// Values are the allowed values of the enum param
+	Values []string
+}
+
+// Args are the validated arguments of an action keyed by the param names
+type Args map[string]string
+
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+func (a Args) String(name string) string {
+	return a[name]
+}
+
+func (a Args) Int(name string) int {
+	value, _ := strconv.Atoi(a[name])
+	return value
+}
+
+func (a Args) Bool(name string) bool {
+	value, _ := strconv.ParseBool(a[name])
+	return value
+}
+
+func (a Args) Quantity(name string) resource.Quantity {
+	value, _ := resource.ParseQuantity(a[name])
+	return value
+}
+
+func (p Param) validate(value string) error {
+	switch p.Type {
+	case ParamInt:
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return newError(ErrCodeInvalidArguments, "invalid %s '%s': should be a positive integer", p.Name, value)
+		}
+	case ParamQuantity:
+		_, err := resource.ParseQuantity(value)
+		if err != nil {
+			return newError(ErrCodeInvalidArguments, "invalid %s '%s': %v", p.Name, value, err)
+		}
+	case ParamEnum:
+		if !contains(p.Values, value) {
+			return newError(ErrCodeInvalidArguments, "invalid %s '%s': should be one of %s", p.Name, value, strings.Join(p.Values, ", "))
+		}
+	case ParamBool:
+		_, err := strconv.ParseBool(value)
+		if err != nil {
+			return newError(ErrCodeInvalidArguments, "invalid %s '%s': should be true or false", p.Name, value)
+		}
+	}
+	return nil
+}
+
+func findParam(params []Param, name string) (Param, bool) {
+	for _, param := range params {
+		if param.Name == name {
+			return param, true
+		}
+	}
+	return Param{}, false
+}
+
+// parseArgs checks the arguments against the params, the empty arguments are ignored
+func parseArgs(params []Param, args []string) (Args, error) {
+	parsed := Args{}
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" {
+			continue
+		}
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+			continue
+		}
+
+		fields := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)
+		name, value, hasValue := fields[0], "", len(fields) == 2
+		if hasValue {
+			value = fields[1]
+		}
+		param, ok := findParam(params, name)
+		if !ok {
+			return nil, newError(ErrCodeInvalidArguments, "unknown option '%s'", arg)
+		}
+		if !hasValue {
+			switch {
+			case param.Type == ParamBool:
+				value = "true"
+			case i+1 < len(args):
+				i++
+				value = args[i]
+			default:
+				return nil, newError(ErrCodeInvalidArguments, "option '%s' requires a value", arg)
+			}
+		}
+		if parsed.Has(name) {
+			return nil, newError(ErrCodeInvalidArguments, "duplicated %s", name)
+		}
+		parsed[name] = value
+	}
+
+	for _, param := range params {
+		if param.Type == ParamBool || parsed.Has(param.Name) {
+			continue
+		}
+		if len(positional) == 0 {
+			break
+		}
+		parsed[param.Name], positional = positional[0], positional[1:]
+	}
+	if len(positional) > 0 {
+		return nil, newError(ErrCodeInvalidArguments, "unexpected arguments: %s", strings.Join(positional, " "))
+	}
+
+	for _, param := range params {
+		value, ok := parsed[param.Name]
+		switch {
+		case ok:
+			err := param.validate(value)
+			if err != nil {
+				return nil, err
+			}
+		case param.Required:
+			return nil, newError(ErrCodeInvalidArguments, "should provide %s", param.Name)
+		case param.Default != "":
+			parsed[param.Name] = param.Default
+		}
+	}
+	return parsed, nil
+}
+
+// usage is generated from the params, e.g. "touch <type> <name> <capacity> [accessMode]"
+func (a Action) usage() string {
+	words := []string{a.Names[0]}
+	for _, param := range a.Params {
+		name := param.Name
+		switch {
+		case param.Type == ParamBool:
+			words = append(words, fmt.Sprintf("[--%s]", name))
+			continue
+		case param.Type == ParamEnum:
+			name = strings.Join(param.Values, "|")
+		}
+		if param.Required {
+			words = append(words, fmt.Sprintf("<%s>", name))
+		} else {
+			words = append(words, fmt.Sprintf("[%s]", name))
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// BuildArgs builds the arguments of the action call by its params. The leading params are given by position,
+// which the servers before the params support, and the ones after an omitted optional param by name.
+func BuildArgs(action string, args Args) ([]string, error) {
+	a, ok := findAction(actions, action)
+	if !ok {
+		return nil, newError(ErrCodeUnsupportedCommand, "Unsupported command '%s'", action)
+	}
+	for name := range args {
+		if _, ok := findParam(a.Params, name); !ok {
+			return nil, newError(ErrCodeInvalidArguments, "action %s has no param %s", action, name)
+		}
+	}
+
+	var built []string
+	positional := true
+	for _, param := range a.Params {
+		value, ok := args[param.Name]
+		if !ok || value == "" {
+			if param.Type == ParamBool {
+				continue
+			}
+			if param.Required {
+				return nil, newError(ErrCodeInvalidArguments, "should provide %s", param.Name)
+			}
+			positional = false
+			continue
+		}
+		err := param.validate(value)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case param.Type == ParamBool:
+			if args.Bool(param.Name) {
+				built = append(built, "--"+param.Name)
+			}
+		case positional:
+			built = append(built, value)
+		default:
+			built = append(built, "--"+param.Name, value)
+		}
+	}
+	return built, nil
+}
@@ -0,0 +1,81 @@
+package commander
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	params := []Param{
+		{Name: "type", Type: ParamEnum, Required: true, Values: []string{"user", "raw"}},
+		{Name: "capacity", Type: ParamQuantity},
+		{Name: "limit", Type: ParamInt, Default: "20"},
+		{Name: "checksum", Type: ParamBool},
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		want    Args
+		errCode ErrorCode
+	}{
+		{"positional", []string{"user", "20Gi", "5"}, Args{"type": "user", "capacity": "20Gi", "limit": "5"}, ""},
+		{"default", []string{"user"}, Args{"type": "user", "limit": "20"}, ""},
+		{"empty ignored", []string{"", "user", ""}, Args{"type": "user", "limit": "20"}, ""},
+		{"by name", []string{"--limit", "5", "user"}, Args{"type": "user", "limit": "5"}, ""},
+		{"by name with value", []string{"--capacity=1Gi", "raw"}, Args{"type": "raw", "capacity": "1Gi", "limit": "20"}, ""},
+		{"bool", []string{"user", "--checksum"}, Args{"type": "user", "limit": "20", "checksum": "true"}, ""},
+		{"bool with value", []string{"user", "--checksum=false"}, Args{"type": "user", "limit": "20", "checksum": "false"}, ""},
+		{"required", nil, nil, ErrCodeInvalidArguments},
+		{"unknown option", []string{"user", "--n", "5"}, nil, ErrCodeInvalidArguments},
+		{"option without value", []string{"user", "--limit"}, nil, ErrCodeInvalidArguments},
+		{"duplicated", []string{"--limit=1", "--limit=2", "user"}, nil, ErrCodeInvalidArguments},
+		{"unexpected", []string{"user", "1Gi", "5", "extra"}, nil, ErrCodeInvalidArguments},
+		{"invalid enum", []string{"dataset"}, nil, ErrCodeInvalidArguments},
+		{"invalid quantity", []string{"user", "lots"}, nil, ErrCodeInvalidArguments},
+		{"invalid int", []string{"user", "--limit", "0"}, nil, ErrCodeInvalidArguments},
+		{"invalid bool", []string{"user", "--checksum=maybe"}, nil, ErrCodeInvalidArguments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseArgs(params, tt.args)
+			if (err != nil || tt.errCode != "") && ErrorCodeOf(err) != tt.errCode {
+				t.Fatalf("parseArgs() error = %v, want %q", err, tt.errCode)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		action  string
+		args    Args
+		want    []string
+		errCode ErrorCode
+	}{
+		{"positional", "touch", Args{"type": "raw", "name": "data", "capacity": "1Gi", "accessMode": "ReadWriteMany"}, []string{"raw", "data", "1Gi", "ReadWriteMany"}, ""},
+		{"optional omitted", "touch", Args{"type": "user", "name": "alice", "capacity": "1Gi"}, []string{"user", "alice", "1Gi"}, ""},
+		{"by name after omitted", "status", Args{"output": "json"}, []string{"--output", "json"}, ""},
+		{"bool", "verify", Args{"pvc": "claim-alice", "checksum": "true"}, []string{"claim-alice", "--checksum"}, ""},
+		{"bool false", "verify", Args{"pvc": "claim-alice", "checksum": "false"}, []string{"claim-alice"}, ""},
+		{"audit limit", "audit", Args{"limit": "5"}, []string{"5"}, ""},
+		{"unknown action", "format", nil, nil, ErrCodeUnsupportedCommand},
+		{"unknown param", "audit", Args{"n": "5"}, nil, ErrCodeInvalidArguments},
+		{"required", "touch", Args{"type": "user", "name": "alice"}, nil, ErrCodeInvalidArguments},
+		{"invalid", "touch", Args{"type": "user", "name": "alice", "capacity": "lots"}, nil, ErrCodeInvalidArguments},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BuildArgs(tt.action, tt.args)
+			if (err != nil || tt.errCode != "") && ErrorCodeOf(err) != tt.errCode {
+				t.Fatalf("BuildArgs() error = %v, want %q", err, tt.errCode)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BuildArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
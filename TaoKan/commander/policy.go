@@ -0,0 +1,244 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const PolicyConfigMapKey = "policy.yaml"
+
+// PolicyRule allows the keys to run the actions on the matched pvcs, an empty field matches anything.
+// The callers of the https api are identified as "token:<name>" or "cert:<common name>",
+// and the ssh callers with a certificate as "cert:<key id>". A rule with pvcTypes or namePatterns only allows
+// the actions on the matched pvcs, not the ones listing the pvcs of the namespace, e.g. status and audit.
+//
+//	rules:
+//	  - fingerprints: ["SHA256:..."]
+//	    namespaces: ["hub", "tenant-*"]
+//	    actions: ["stat", "touch", "mount", "umount"]
+//	    pvcTypes: ["user", "project"]
+//	    namePatterns: ["claim-*", "data-nfs-project-*"]
+//	    maxCapacity: 100Gi
+type PolicyRule struct {
+	Fingerprints []string `json:"fingerprints"`
+	Namespaces   []string `json:"namespaces"`
+	Actions      []string `json:"actions"`
+	PvcTypes     []string `json:"pvcTypes"`
+	NamePatterns []string `json:"namePatterns"`
+	MaxCapacity  string   `json:"maxCapacity"`
+
+	maxCapacity *resource.Quantity
+}
+
+type Policy struct {
+	Rules []PolicyRule `json:"rules"`
+}
+
+// actionTarget is the pvc an action operates on
+type actionTarget struct {
+	PvcType  string
+	PvcName  string
+	Capacity *resource.Quantity
+}
+
+// Caller identifies the client of an action
+type Caller struct {
+	Fingerprint string
+	Address     string
+	// Principals are the namespaces of the caller's certificate, any namespace if empty
+	Principals []string
+	// Expiry of the caller's certificate, zero if no certificate or no expiry
+	Expiry time.Time
+}
+
+func parsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	err := yaml.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		if rule.MaxCapacity == "" {
+			continue
+		}
+		capacity, err := resource.ParseQuantity(rule.MaxCapacity)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: invalid maxCapacity: %v", i, err)
+		}
+		policy.Rules[i].maxCapacity = &capacity
+	}
+	return &policy, nil
+}
+
+// loadPolicy returns nil if no policy configured, which allows every key to run every action
+func loadPolicy(config Config) (*Policy, error) {
+	var data []byte
+	switch {
+	case config.PolicyFile != "":
+		log.Infof("[Load] Policy from file %s", config.PolicyFile)
+		content, err := os.ReadFile(config.PolicyFile)
+		if err != nil {
+			return nil, err
+		}
+		data = content
+	case config.PolicyConfigMap != "":
+		log.Infof("[Load] Policy from config map %s/%s", Namespace, config.PolicyConfigMap)
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		configMap, err := k8s.GetConfigMap(Namespace, config.PolicyConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		content, ok := configMap.Data[PolicyConfigMapKey]
+		if !ok {
+			return nil, fmt.Errorf("config map %s has no key '%s'", config.PolicyConfigMap, PolicyConfigMapKey)
+		}
+		data = []byte(content)
+	default:
+		log.Warnf("[Policy] No policy configured, all the authorized keys can run all the actions")
+		return nil, nil
+	}
+
+	policy, err := parsePolicy(data)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("[Policy] %d rules loaded", len(policy.Rules))
+	return policy, nil
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == value {
+			return true
+		}
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r PolicyRule) allows(fingerprint string, action Action, namespace string, target *actionTarget) bool {
+	if !matchAny(r.Fingerprints, fingerprint) || !matchAny(r.Namespaces, namespace) {
+		return false
+	}
+	if len(r.Actions) > 0 {
+		allowed := false
+		for _, name := range action.Names {
+			allowed = allowed || matchAny(r.Actions, name)
+		}
+		if !allowed {
+			return false
+		}
+	}
+	if target == nil {
+		// The rule limited to some pvcs does not allow the actions which list the pvcs without a target,
+		// e.g. status and audit, the version handshake reveals no pvc
+		return action.Names[0] == "version" || len(r.PvcTypes) == 0 && len(r.NamePatterns) == 0
+	}
+	if !matchAny(r.PvcTypes, target.PvcType) || !matchAny(r.NamePatterns, target.PvcName) {
+		return false
+	}
+	if r.maxCapacity != nil && target.Capacity != nil && target.Capacity.Cmp(*r.maxCapacity) > 0 {
+		return false
+	}
+	return true
+}
+
+// authorize checks whether the caller is allowed to run the action on the target in the namespace
+func (p *Policy) authorize(caller Caller, action Action, namespace string, target *actionTarget) error {
+	if p == nil {
+		return nil
+	}
+	for _, rule := range p.Rules {
+		if rule.allows(caller.Fingerprint, action, namespace, target) {
+			return nil
+		}
+	}
+	if target != nil {
+		return newError(ErrCodeForbidden, "key %s is not allowed to %s %s pvc %s/%s", caller.Fingerprint, action.Names[0], target.PvcType, namespace, target.PvcName)
+	}
+	return newError(ErrCodeForbidden, "key %s is not allowed to %s in namespace %s", caller.Fingerprint, action.Names[0], namespace)
+}
+
+// pvcTypeOf returns the type recorded on the pvc by touch, the pvcs created by others are typed
+// by the naming convention of PrimeHub
+func pvcTypeOf(pvc *v1.PersistentVolumeClaim) string {
+	if pvcType, ok := pvc.Labels[KubernetesAPI.PvcTypeLabel]; ok {
+		return pvcType
+	}
+	return pvcTypeByName(pvc.Name)
+}
+
+func pvcTypeByName(pvcName string) string {
+	switch {
+	case strings.HasPrefix(pvcName, KubernetesAPI.UserPvcPrefix):
+		return "user"
+	case strings.HasPrefix(pvcName, KubernetesAPI.DatasetDataPvcPrefix), strings.HasPrefix(pvcName, KubernetesAPI.DatasetPvcPrefix):
+		return "dataset"
+	case strings.HasPrefix(pvcName, KubernetesAPI.ProjectDataPvcPrefix), strings.HasPrefix(pvcName, KubernetesAPI.ProjectPvcPrefix):
+		return "project"
+	}
+	return "raw"
+}
+
+// existingPvcType returns the type of the pvc in the namespace, empty if the pvc does not exist
+func existingPvcType(namespace string, pvcName string) (string, error) {
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	pvc, err := k8s.FindPvc(namespace, pvcName)
+	if err != nil || pvc == nil {
+		return "", err
+	}
+	return pvcTypeOf(pvc), nil
+}
+
+// pvcTarget is the target of the actions taking the pvc param
+func pvcTarget(namespace string, args Args) (*actionTarget, error) {
+	pvcName := args.String("pvc")
+	pvcType, err := existingPvcType(namespace, pvcName)
+	if err != nil {
+		return nil, err
+	}
+	if pvcType == "" {
+		pvcType = pvcTypeByName(pvcName)
+	}
+	return &actionTarget{PvcType: pvcType, PvcName: pvcName}, nil
+}
+
+// touchTarget is the target of the touch action, which takes the pvc type, name and capacity.
+// The existing pvc keeps its type, whatever type it is touched as.
+func touchTarget(namespace string, args Args) (*actionTarget, error) {
+	pvcType, name := args.String("type"), args.String("name")
+	capacity := args.Quantity("capacity")
+
+	target := &actionTarget{PvcType: pvcType, Capacity: &capacity}
+	switch pvcType {
+	case "user":
+		target.PvcName = KubernetesAPI.UserPvcPrefix + name
+	case "project":
+		target.PvcName = KubernetesAPI.ProjectDataPvcPrefix + name + KubernetesAPI.ProjectDataPvcPostfix
+	case "dataset":
+		target.PvcName = KubernetesAPI.DatasetDataPvcPrefix + name + KubernetesAPI.DatasetDataPvcPostfix
+	default:
+		target.PvcName = name
+	}
+	existingType, err := existingPvcType(namespace, target.PvcName)
+	if err != nil {
+		return nil, err
+	}
+	if existingType != "" {
+		target.PvcType = existingType
+	}
+	return target, nil
+}
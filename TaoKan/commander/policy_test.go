@@ -0,0 +1,156 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+const testPolicy = `
+rules:
+  - fingerprints: ["SHA256:admin"]
+  - fingerprints: ["SHA256:monitor"]
+    actions: ["status", "version"]
+  - fingerprints: ["SHA256:backup", "token:ci-*"]
+    namespaces: ["hub", "tenant-*"]
+    actions: ["stat", "touch", "mount", "umount"]
+    pvcTypes: ["user", "project"]
+    namePatterns: ["claim-*", "data-nfs-project-*"]
+    maxCapacity: 100Gi
+`
+
+func TestPolicyAuthorize(t *testing.T) {
+	policy, err := parsePolicy([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := Action{Names: []string{"stat"}}
+	umount := Action{Names: []string{"unmount", "umount"}}
+	purge := Action{Names: []string{"purge"}}
+	version := Action{Names: []string{"version", "capabilities"}}
+	status := Action{Names: []string{"status"}}
+	target := func(pvcType string, name string, capacity string) *actionTarget {
+		t := &actionTarget{PvcType: pvcType, PvcName: name}
+		if capacity != "" {
+			quantity := resource.MustParse(capacity)
+			t.Capacity = &quantity
+		}
+		return t
+	}
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		action      Action
+		namespace   string
+		target      *actionTarget
+		allowed     bool
+	}{
+		{"admin runs anything", "SHA256:admin", purge, "other", target("dataset", "dataset-x", ""), true},
+		{"unknown key", "SHA256:unknown", stat, "hub", target("user", "claim-alice", ""), false},
+		{"matched rule", "SHA256:backup", stat, "hub", target("user", "claim-alice", ""), true},
+		{"token pattern", "token:ci-nightly", stat, "tenant-a", target("project", "data-nfs-project-x", ""), true},
+		{"namespace not matched", "SHA256:backup", stat, "default", target("user", "claim-alice", ""), false},
+		{"action not matched", "SHA256:backup", purge, "hub", target("user", "claim-alice", ""), false},
+		{"action alias matched", "SHA256:backup", umount, "hub", target("user", "claim-alice", ""), true},
+		{"action without target", "SHA256:backup", version, "hub", nil, false},
+		{"listing by a rule limited to pvcs", "SHA256:backup", status, "hub", nil, false},
+		{"listing by a rule of any pvc", "SHA256:monitor", status, "hub", nil, true},
+		{"version by a rule of any pvc", "SHA256:monitor", version, "hub", nil, true},
+		{"pvc type not matched", "SHA256:backup", stat, "hub", target("dataset", "claim-alice", ""), false},
+		{"name not matched", "SHA256:backup", stat, "hub", target("user", "dataset-alice", ""), false},
+		{"capacity at the limit", "SHA256:backup", stat, "hub", target("user", "claim-alice", "100Gi"), true},
+		{"capacity over the limit", "SHA256:backup", stat, "hub", target("user", "claim-alice", "101Gi"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.authorize(Caller{Fingerprint: tt.fingerprint}, tt.action, tt.namespace, tt.target)
+			if (err == nil) != tt.allowed {
+				t.Fatalf("authorize() = %v, allowed %v", err, tt.allowed)
+			}
+			if err != nil && ErrorCodeOf(err) != ErrCodeForbidden {
+				t.Errorf("authorize() code = %s, want %s", ErrorCodeOf(err), ErrCodeForbidden)
+			}
+		})
+	}
+}
+
+func TestPolicyVersionHandshake(t *testing.T) {
+	policy, err := parsePolicy([]byte("rules:\n  - fingerprints: [\"SHA256:backup\"]\n    actions: [\"version\", \"status\"]\n    pvcTypes: [\"user\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller := Caller{Fingerprint: "SHA256:backup"}
+	if err := policy.authorize(caller, Action{Names: []string{"version", "capabilities"}}, "hub", nil); err != nil {
+		t.Errorf("authorize(version) = %v, want allowed", err)
+	}
+	if err := policy.authorize(caller, Action{Names: []string{"status"}}, "hub", nil); err == nil {
+		t.Error("authorize(status) is allowed by the rule limited to the user pvcs")
+	}
+}
+
+func TestPvcTypeOf(t *testing.T) {
+	pvc := func(name string, labels map[string]string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	typed := func(pvcType string) map[string]string {
+		return map[string]string{KubernetesAPI.PvcTypeLabel: pvcType}
+	}
+	tests := []struct {
+		pvc  *v1.PersistentVolumeClaim
+		want string
+	}{
+		{pvc("claim-alice", nil), "user"},
+		{pvc("project-x", nil), "project"},
+		{pvc("data-nfs-project-x-0", nil), "project"},
+		{pvc("dataset-x", nil), "dataset"},
+		{pvc("data-nfs-dataset-x-0", nil), "dataset"},
+		{pvc("data", nil), "raw"},
+		{pvc("claim-alice", typed("raw")), "raw"},
+		{pvc("project-x", typed("raw")), "raw"},
+		{pvc("data", typed("user")), "user"},
+	}
+	for _, tt := range tests {
+		if got := pvcTypeOf(tt.pvc); got != tt.want {
+			t.Errorf("pvcTypeOf(%s, %v) = %s, want %s", tt.pvc.Name, tt.pvc.Labels, got, tt.want)
+		}
+	}
+}
+
+func TestNoPolicyAllowsAll(t *testing.T) {
+	var policy *Policy
+	err := policy.authorize(Caller{Fingerprint: "SHA256:any"}, Action{Names: []string{"purge"}}, "any", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParsePolicyInvalidCapacity(t *testing.T) {
+	_, err := parsePolicy([]byte("rules:\n  - maxCapacity: lots\n"))
+	if err == nil {
+		t.Fatal("parsePolicy() accepted an invalid maxCapacity")
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		value    string
+		want     bool
+	}{
+		{nil, "anything", true},
+		{[]string{"*"}, "anything", true},
+		{[]string{"hub"}, "hub", true},
+		{[]string{"hub"}, "hub2", false},
+		{[]string{"tenant-*"}, "tenant-a", true},
+		{[]string{"tenant-*"}, "hub", false},
+		{[]string{"a", "b"}, "b", true},
+	}
+	for _, tt := range tests {
+		if got := matchAny(tt.patterns, tt.value); got != tt.want {
+			t.Errorf("matchAny(%v, %s) = %v, want %v", tt.patterns, tt.value, got, tt.want)
+		}
+	}
+}
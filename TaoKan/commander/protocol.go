@@ -0,0 +1,267 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"time"
+)
+
+// ProtocolVersion is the version of the JSON response envelope
+const ProtocolVersion = 1
+
+// ProtocolEnv is the session environment variable a client sets to negotiate the response format.
+// Sessions without it get the plain text format, which is what the legacy clients expect.
+const ProtocolEnv = "TAOKAN_PROTOCOL"
+
+type Protocol string
+
+const (
+	TextProtocol Protocol = "text"
+	JSONProtocol Protocol = "json"
+)
+
+type ResponseStatus string
+
+const (
+	StatusOK       ResponseStatus = "ok"
+	StatusError    ResponseStatus = "error"
+	StatusProgress ResponseStatus = "progress"
+)
+
+// Response is the envelope returned by every action in the JSON protocol
+type Response struct {
+	Version int             `json:"version"`
+	Status  ResponseStatus  `json:"status"`
+	Code    ErrorCode       `json:"code,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// RetryAfterMs is how long the client should wait before retrying the throttled action
+	RetryAfterMs int64 `json:"retryAfterMs,omitempty"`
+}
+
+// Progress is the payload of the progress lines streamed before the final response
+type Progress struct {
+	Pvc     string `json:"pvc"`
+	Stage   string `json:"stage"`
+	Message string `json:"message,omitempty"`
+}
+
+// PvcSummary is the status of a pvc, the name and the pods using it are what the earlier servers report
+type PvcSummary = KubernetesAPI.PvcStatus
+
+type StatusResult struct {
+	User    []PvcSummary `json:"user"`
+	Dataset []PvcSummary `json:"dataset"`
+	Project []PvcSummary `json:"project"`
+}
+
+func newStatusResult(records []KubernetesAPI.PvcStatus) StatusResult {
+	result := StatusResult{User: []PvcSummary{}, Dataset: []PvcSummary{}, Project: []PvcSummary{}}
+	for _, record := range records {
+		switch record.Type {
+		case "user":
+			result.User = append(result.User, record)
+		case "dataset":
+			result.Dataset = append(result.Dataset, record)
+		case "project":
+			result.Project = append(result.Project, record)
+		}
+	}
+	return result
+}
+
+// Records lists the pvcs of all the types, the type is filled for the result of the earlier servers
+func (r StatusResult) Records() []KubernetesAPI.PvcStatus {
+	records := make([]KubernetesAPI.PvcStatus, 0)
+	for _, group := range []struct {
+		pvcType string
+		pvcs    []PvcSummary
+	}{
+		{"user", r.User},
+		{"dataset", r.Dataset},
+		{"project", r.Project},
+	} {
+		for _, pvc := range group.pvcs {
+			pvc.Type = group.pvcType
+			records = append(records, pvc)
+		}
+	}
+	return records
+}
+
+type MountResult struct {
+	Pvc       string `json:"pvc"`
+	ServerPod string `json:"serverPod"`
+	Ready     bool   `json:"ready"`
+}
+
+type UmountResult struct {
+	Pvc       string `json:"pvc"`
+	ServerPod string `json:"serverPod,omitempty"`
+}
+
+type PvcStat struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace"`
+	Phase             string            `json:"phase"`
+	Capacity          string            `json:"capacity,omitempty"`
+	RequestedCapacity string            `json:"requestedCapacity"`
+	StorageClass      string            `json:"storageClass,omitempty"`
+	AccessModes       []string          `json:"accessModes"`
+	VolumeName        string            `json:"volumeName,omitempty"`
+	CreationTimestamp time.Time         `json:"creationTimestamp"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	UsedBy            []string          `json:"usedBy,omitempty"`
+	RsyncServer       string            `json:"rsyncServer,omitempty"`
+}
+
+type PurgeResult struct {
+	Pvc     string `json:"pvc"`
+	DryRun  bool   `json:"dryRun"`
+	Token   string `json:"token,omitempty"`
+	Deleted bool   `json:"deleted"`
+}
+
+type TouchResult struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Capacity string `json:"capacity"`
+}
+
+func sessionProtocol(environ []string) Protocol {
+	for _, env := range environ {
+		if env == ProtocolEnv+"="+string(JSONProtocol) {
+			return JSONProtocol
+		}
+	}
+	return TextProtocol
+}
+
+// progressReporter is implemented by the session writers which stream the progress to the client
+type progressReporter interface {
+	Progress(progress Progress)
+}
+
+// sessionWriter is the writer of the actions, the free text goes to out and the progress to the session
+type sessionWriter struct {
+	out      io.Writer
+	session  io.Writer
+	protocol Protocol
+}
+
+func (w *sessionWriter) Write(p []byte) (int, error) {
+	return w.out.Write(p)
+}
+
+func (w *sessionWriter) Progress(progress Progress) {
+	if w.protocol == JSONProtocol {
+		response := newResponse(progress, nil)
+		response.Status = StatusProgress
+		writeResponse(w.session, response)
+		return
+	}
+	fmt.Fprintf(w.session, "[Progress] %s: %s\n", progress.Stage, progress.Message)
+}
+
+// reportProgress returns the progress func of the pvc, which reports to the writer if it supports the progress
+func reportProgress(w io.Writer, pvcName string) KubernetesAPI.ProgressFunc {
+	return func(stage string, message string) {
+		log.Infof("[Progress] Pvc: %s %s: %s", pvcName, stage, message)
+		if reporter, ok := w.(progressReporter); ok {
+			reporter.Progress(Progress{Pvc: pvcName, Stage: stage, Message: message})
+		}
+	}
+}
+
+func newResponse(payload interface{}, err error) *Response {
+	response := &Response{
+		Version: ProtocolVersion,
+		Status:  StatusOK,
+	}
+	if err != nil {
+		e := toError(err)
+		response.Status = StatusError
+		response.Code = e.Code
+		response.Message = e.Message
+		response.RetryAfterMs = e.RetryAfter.Milliseconds()
+		return response
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return newResponse(nil, err)
+		}
+		response.Payload = data
+	}
+	return response
+}
+
+func writeResponse(w io.Writer, response *Response) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// parseResponse reads the envelope from the last line of the output
+func parseResponse(output []byte) (*Response, error) {
+	lines := bytes.Split(bytes.TrimSpace(output), []byte("\n"))
+	var response Response
+	err := json.Unmarshal(lines[len(lines)-1], &response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Version == 0 || response.Status == "" {
+		return nil, errors.New("invalid response envelope")
+	}
+	return &response, nil
+}
+
+// legacyResponse wraps the text output of a server which does not speak the JSON protocol
+func legacyResponse(action string, output string, err error) *Response {
+	if err != nil {
+		message := output
+		if index := strings.LastIndex(output, "[Error] "); index >= 0 {
+			message = output[index+len("[Error] "):]
+		}
+		e := toClientError(err, strings.TrimSpace(message))
+		// The legacy servers exit 75 for every failure, including the actions they do not have
+		if strings.Contains(e.Message, "Unsupported command") {
+			e.Code = ErrCodeUnsupportedCommand
+		}
+		return &Response{Status: StatusError, Code: e.Code, Message: e.Message}
+	}
+
+	var payload interface{}
+	switch action {
+	case "mount":
+		payload = MountResult{Ready: strings.Contains(output, "Server pod ready:")}
+	}
+	response := newResponse(payload, nil)
+	response.Version = 0
+	response.Message = output
+	return response
+}
+
+func (r *Response) Err() error {
+	if r.Status == StatusError {
+		return &Error{Code: r.Code, Message: r.Message, RetryAfter: time.Duration(r.RetryAfterMs) * time.Millisecond}
+	}
+	return nil
+}
+
+// Decode unmarshals the payload of the response into v
+func (r *Response) Decode(v interface{}) error {
+	if len(r.Payload) == 0 {
+		return errors.New("empty response payload")
+	}
+	return json.Unmarshal(r.Payload, v)
+}
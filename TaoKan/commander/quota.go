@@ -0,0 +1,132 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sync"
+)
+
+// storageQuota checks the storage requested by touch against the ResourceQuotas of the namespace,
+// the maximum capacity of each pvc type, and the budget of all the pvcs created by TaoKan in the cluster
+type storageQuota struct {
+	maxCapacity map[string]resource.Quantity
+	budget      *resource.Quantity
+
+	// mu serializes the touch actions when the budget is enabled, so they won't exceed it together
+	mu sync.Mutex
+}
+
+var quota = &storageQuota{}
+
+func newStorageQuota(maxCapacity map[string]string, budget string) (*storageQuota, error) {
+	q := &storageQuota{maxCapacity: map[string]resource.Quantity{}}
+	for pvcType, value := range maxCapacity {
+		if !contains(PvcTypes, pvcType) {
+			return nil, fmt.Errorf("invalid max capacity: unsupported pvc type '%s'", pvcType)
+		}
+		capacity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max capacity of %s pvc: %v", pvcType, err)
+		}
+		q.maxCapacity[pvcType] = capacity
+		log.Infof("[Quota] Max capacity of %s pvc: %s", pvcType, capacity.String())
+	}
+	if budget != "" {
+		capacity, err := resource.ParseQuantity(budget)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage budget: %v", err)
+		}
+		q.budget = &capacity
+		log.Infof("[Quota] Storage budget: %s", capacity.String())
+	}
+	return q, nil
+}
+
+// lock serializes the checks and the creations of the pvcs, the returned func unlocks
+func (q *storageQuota) lock() func() {
+	if q.budget == nil {
+		return func() {}
+	}
+	q.mu.Lock()
+	return q.mu.Unlock
+}
+
+// check rejects the pvc whose capacity exceeds the limits. The existing pvc is only checked
+// for the storage added by the expansion.
+func (q *storageQuota) check(namespace string, pvcType string, pvcName string, capacity resource.Quantity) error {
+	if max, ok := q.maxCapacity[pvcType]; ok && capacity.Cmp(max) > 0 {
+		return newError(ErrCodeQuotaExceeded, "capacity %s of pvc %s exceeds the max capacity %s of %s pvc",
+			capacity.String(), pvcName, max.String(), pvcType)
+	}
+
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	added := capacity.DeepCopy()
+	newPvc := true
+	pvc, _, err := k8s.GetPvc(namespace, pvcName)
+	switch {
+	case err == nil:
+		newPvc = false
+		added.Sub(*pvc.Spec.Resources.Requests.Storage())
+		if added.Sign() <= 0 {
+			return nil
+		}
+	case !k8sErrors.IsNotFound(err):
+		return err
+	}
+
+	quotas, err := k8s.ListResourceQuotas(namespace)
+	if err != nil {
+		return err
+	}
+	for _, resourceQuota := range quotas {
+		err = checkResourceQuota(resourceQuota, pvcName, added, newPvc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if q.budget == nil {
+		return nil
+	}
+	pvcs, err := k8s.ListTaoKanPvcs("")
+	if err != nil {
+		return err
+	}
+	used := resource.Quantity{}
+	for _, pvc := range pvcs {
+		used.Add(*pvc.Spec.Resources.Requests.Storage())
+	}
+	requested := used.DeepCopy()
+	requested.Add(added)
+	if requested.Cmp(*q.budget) > 0 {
+		return newError(ErrCodeQuotaExceeded, "pvc %s requests %s more storage, exceeds the storage budget: used %s of %s",
+			pvcName, added.String(), used.String(), q.budget.String())
+	}
+	return nil
+}
+
+// checkResourceQuota checks the storage and the pvc count of the quota
+func checkResourceQuota(resourceQuota v1.ResourceQuota, pvcName string, added resource.Quantity, newPvc bool) error {
+	requests := map[v1.ResourceName]resource.Quantity{v1.ResourceRequestsStorage: added}
+	if newPvc {
+		requests[v1.ResourcePersistentVolumeClaims] = *resource.NewQuantity(1, resource.DecimalSI)
+	}
+	for name, request := range requests {
+		hard, ok := resourceQuota.Status.Hard[name]
+		if !ok {
+			continue
+		}
+		used := resourceQuota.Status.Used[name]
+		requested := used.DeepCopy()
+		requested.Add(request)
+		if requested.Cmp(hard) > 0 {
+			return newError(ErrCodeQuotaExceeded, "pvc %s exceeds the %s of resource quota %s: requested %s, used %s, limited %s",
+				pvcName, name, resourceQuota.Name, request.String(), used.String(), hard.String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultDrainTimeout = 5 * time.Minute
+	// ShutdownRequest is the global request noticing the clients that the server is shutting down,
+	// the clients should connect again for the next actions
+	ShutdownRequest = "shutdown@taokan"
+	// sessionRetryAfter is the hint to retry the session rejected by the limit of the concurrent sessions
+	sessionRetryAfter = 5 * time.Second
+)
+
+// background tracks the work the actions leave running after they returned, e.g. deleting the rsync-server pod
+var background sync.WaitGroup
+
+// Server serves the actions over ssh until it is shut down
+type Server struct {
+	commander *Commander
+	ssh       *ssh.Server
+	api       *httpAPI
+	config    Config
+
+	mu       sync.Mutex
+	draining bool
+	inflight sync.WaitGroup
+	// listeners are closed once draining, conns are the ssh connections to notice then
+	listeners []net.Listener
+	conns     map[string]*trackedConn
+	// sessions limits the concurrent ssh sessions and https requests, unlimited if nil
+	sessions chan struct{}
+}
+
+func NewServer(config Config) (*Server, error) {
+	commander := &Commander{
+		Port:    config.Port,
+		Mode:    ServerMode,
+		Actions: actions,
+		config:  config,
+	}
+	KubeConfig = config.KubeConfig
+	Namespace = config.Namespace
+	if config.Version != "" {
+		Version = config.Version
+	}
+	allowedNamespaces = config.AllowedNamespaces
+	createNamespace = config.CreateNamespace
+	if len(allowedNamespaces) > 0 {
+		log.Infof("[Namespace] Allowed namespaces: %s", strings.Join(allowedNamespaces, ", "))
+	}
+
+	// Config the specified Storage Class
+	if config.StorageClassRWX != "" || config.StorageClassRWO != "" {
+		k8s := KubernetesAPI.GetInstance(KubeConfig)
+		k8s.SetRwoStorageClass(config.StorageClassRWO)
+		k8s.SetRwxStorageClass(config.StorageClassRWX)
+	}
+
+	auth, err := loadClientAuth(config)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := loadHostKey(config)
+	if err != nil {
+		return nil, err
+	}
+	commander.policy, err = loadPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+	auditLogger, err = newAuditLogger(config)
+	if err != nil {
+		return nil, err
+	}
+	locks = newPvcLocks(config.LockWait, config.LockLease)
+	throttle = newThrottler(config.RateLimit, config.RateBurst, config.MaxMounts)
+	quota, err = newStorageQuota(config.MaxCapacity, config.StorageBudget)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{commander: commander, config: config, conns: map[string]*trackedConn{}}
+	if config.MaxSessions > 0 {
+		server.sessions = make(chan struct{}, config.MaxSessions)
+	}
+	actionTimeouts = newActionTimeouts(config.ActionTimeout, config.ActionTimeouts)
+	server.ssh = &ssh.Server{
+		Addr:         fmt.Sprintf(":%d", config.Port),
+		Handler:      server.handle,
+		ConnCallback: server.trackConn,
+		IdleTimeout:  config.IdleTimeout,
+		MaxTimeout:   config.ConnLifetime,
+	}
+	for _, option := range []ssh.Option{ssh.PublicKeyAuth(auth.publicKeyHandler), ssh.HostKeyPEM(hostKey)} {
+		err = server.ssh.SetOption(option)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.HTTPPort != 0 {
+		server.api, err = newHTTPAPI(server, config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	serverInstance = commander
+	return server, nil
+}
+
+// Start serves until the context is done or the server fails
+func (s *Server) Start(ctx context.Context) error {
+	log.Infof("Start ssh server at %s", s.ssh.Addr)
+	sshListener, err := s.listen(s.ssh.Addr)
+	if err != nil {
+		return err
+	}
+	errCh := make(chan error, 2)
+	go func() {
+		errCh <- s.ssh.Serve(sshListener)
+	}()
+	if s.api != nil {
+		log.Infof("Start https server at %s", s.api.http.Addr)
+		apiListener, err := s.listen(s.api.http.Addr)
+		if err != nil {
+			return err
+		}
+		go func() {
+			errCh <- s.api.http.ServeTLS(apiListener, s.config.TLSCertFile, s.config.TLSKeyFile)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if isClosed(err) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// Shutdown rejects the new actions and waits for the in-flight ones, then closes the connections.
+// If the context is done before the actions finished, they are abandoned and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.draining = true
+	listeners := s.listeners
+	var conns []gossh.Conn
+	for _, conn := range s.conns {
+		if conn.ssh != nil {
+			conns = append(conns, conn.ssh)
+		}
+	}
+	s.mu.Unlock()
+	log.Infof("[Shutdown] Draining, wait for the in-flight actions")
+
+	// Stop accepting the connections, and notice the connected clients to go elsewhere
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	if s.api != nil {
+		s.api.http.SetKeepAlivesEnabled(false)
+	}
+	noticed := 0
+	for _, conn := range conns {
+		_, _, err := conn.SendRequest(ShutdownRequest, false, nil)
+		if err == nil {
+			noticed++
+		}
+	}
+	log.Infof("[Shutdown] Noticed %d connections", noticed)
+
+	finished := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		background.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+		log.Infof("[Shutdown] All actions finished")
+	case <-ctx.Done():
+		err = ctx.Err()
+		log.Warnf("[Shutdown] Drain timeout, abandon the in-flight actions")
+	}
+	closeErr := s.ssh.Close()
+	if s.api != nil {
+		if apiErr := s.api.http.Close(); closeErr == nil {
+			closeErr = apiErr
+		}
+	}
+	if err == nil && !isClosed(closeErr) {
+		err = closeErr
+	}
+	return err
+}
+
+// listen opens the listener closed once the server starts draining
+func (s *Server) listen(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+	return listener, nil
+}
+
+// isClosed reports whether the error is by closing the server or its listeners
+func isClosed(err error) bool {
+	return err == nil || errors.Is(err, ssh.ErrServerClosed) || errors.Is(err, http.ErrServerClosed) || errors.Is(err, net.ErrClosed)
+}
+
+// trackedConn is a connection of the ssh server until it is closed, its ssh side is attached by its first session
+type trackedConn struct {
+	net.Conn
+	server *Server
+	once   sync.Once
+	closed chan struct{}
+	// ssh is set once under the mutex of the server
+	ssh gossh.Conn
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.server.mu.Lock()
+		delete(c.server.conns, c.RemoteAddr().String())
+		c.server.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// trackConn is the ConnCallback of the ssh server
+func (s *Server) trackConn(ctx ssh.Context, conn net.Conn) net.Conn {
+	tracked := &trackedConn{Conn: conn, server: s, closed: make(chan struct{})}
+	s.mu.Lock()
+	s.conns[conn.RemoteAddr().String()] = tracked
+	s.mu.Unlock()
+	return tracked
+}
+
+// attachConn attaches the ssh side to the connection of the session, it reports whether it is attached by this session
+func (s *Server) attachConn(session ssh.Session) (*trackedConn, bool) {
+	sshConn, ok := session.Context().Value(ssh.ContextKeyConn).(gossh.Conn)
+	if !ok {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tracked := s.conns[session.RemoteAddr().String()]
+	if tracked == nil || tracked.ssh != nil {
+		return tracked, false
+	}
+	tracked.ssh = sshConn
+	return tracked, true
+}
+
+// openSession takes a slot of the concurrent sessions, the returned func releases it
+func (s *Server) openSession() (func(), error) {
+	if s.sessions == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.sessions <- struct{}{}:
+		return func() { <-s.sessions }, nil
+	default:
+		log.Warnf("[Throttled] %d concurrent sessions reached the limit", cap(s.sessions))
+		return nil, &Error{
+			Code:       ErrCodeThrottled,
+			Message:    "too many sessions, retry later",
+			RetryAfter: sessionRetryAfter,
+		}
+	}
+}
+
+// begin registers an in-flight action, it fails if the server is draining
+func (s *Server) begin() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return newError(ErrCodeShuttingDown, "server is shutting down, retry later")
+	}
+	s.inflight.Add(1)
+	return nil
+}
+
+func (s *Server) handle(session ssh.Session) {
+	caller := sessionCaller(session)
+	fingerprint := caller.Fingerprint
+	protocol := sessionProtocol(session.Environ())
+	log.Infof("[Receive] Key: %s Command: `%s`", fingerprint, strings.Join(session.Command(), " "))
+
+	if tracked, attached := s.attachConn(session); attached && s.config.KeepAliveInterval > 0 {
+		go keepConnAlive(tracked, s.config.KeepAliveInterval)
+	}
+
+	closeSession, err := s.openSession()
+	if err == nil {
+		defer closeSession()
+		if _, windows, isPty := session.Pty(); isPty && len(session.Command()) == 0 {
+			s.shell(session, caller, windows)
+			return
+		}
+	}
+
+	w := &sessionWriter{out: session, session: session, protocol: protocol}
+	if protocol == JSONProtocol {
+		// Free text output is only for the legacy clients
+		w.out = io.Discard
+	} else {
+		io.WriteString(session, welcomeMsg)
+	}
+
+	var payload interface{}
+	if err == nil {
+		err = s.begin()
+		if err == nil {
+			payload, err = serverCommandDispatcher(s.commander, caller, w, session.Command())
+			s.inflight.Done()
+		}
+	}
+
+	if protocol == JSONProtocol {
+		writeResponse(session, newResponse(payload, err))
+	} else if err != nil {
+		io.WriteString(session, "[Error] "+err.Error())
+	}
+	exitStatus := ExitSuccess
+	if err != nil {
+		log.Error(err)
+		exitStatus = toError(err).ExitStatus()
+	}
+	log.Infof("[Closed] Key: %s Command: `%s` Exit: %d", fingerprint, strings.Join(session.Command(), " "), exitStatus)
+	session.Exit(exitStatus)
+}
+
+// keepConnAlive pings the client periodically until the connection is closed, and closes the connection
+// if the client stopped answering
+func keepConnAlive(conn *trackedConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-conn.closed:
+			return
+		case <-ticker.C:
+			_, _, err := conn.ssh.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				log.Warnf("[Keepalive] Client %s is gone: %v", conn.RemoteAddr(), err)
+				conn.ssh.Close()
+				return
+			}
+		}
+	}
+}
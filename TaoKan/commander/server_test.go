@@ -0,0 +1,99 @@
+package commander
+
+import (
+	"context"
+	"github.com/gliderlabs/ssh"
+	"net"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShutdownNoticesClientsAndStopsListening(t *testing.T) {
+	s := &Server{conns: map[string]*trackedConn{}}
+	s.ssh = &ssh.Server{
+		Addr: "127.0.0.1:0",
+		Handler: func(session ssh.Session) {
+			s.attachConn(session)
+			session.Exit(0)
+		},
+		ConnCallback: s.trackConn,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Start(ctx)
+
+	var addr *net.TCPAddr
+	waitFor(t, "listener", func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if len(s.listeners) == 0 {
+			return false
+		}
+		addr = s.listeners[0].Addr().(*net.TCPAddr)
+		return true
+	})
+	c := &Commander{Remote: "127.0.0.1", Port: uint(addr.Port), Mode: ClientMode, config: Config{InsecureIgnoreHostKey: true}}
+	err := c.connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.currentClient().Close()
+	// The connection is attached by its first session
+	session, err := c.newSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = session.Run("version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	attached := len(s.conns) == 1
+	for _, conn := range s.conns {
+		attached = attached && conn.ssh != nil
+	}
+	s.mu.Unlock()
+	if !attached {
+		t.Fatal("the connection is not attached by its session")
+	}
+
+	// An action in flight holds the shutdown
+	s.inflight.Add(1)
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- s.Shutdown(shutdownCtx)
+	}()
+
+	waitFor(t, "shutdown notice", c.serverDraining)
+	conn, err := net.DialTimeout("tcp", addr.String(), time.Second)
+	if err == nil {
+		conn.Close()
+		t.Fatal("the draining server accepted a new connection")
+	}
+	if err := s.begin(); ErrorCodeOf(err) != ErrCodeShuttingDown {
+		t.Fatalf("begin() = %v, want %s", err, ErrCodeShuttingDown)
+	}
+
+	s.inflight.Done()
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not finish after the actions finished")
+	}
+}
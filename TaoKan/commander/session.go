@@ -0,0 +1,161 @@
+package commander
+
+import (
+	"fmt"
+	"github.com/melbahja/goph"
+	log "github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"net"
+	"time"
+)
+
+const DefaultKeepAliveInterval = 30 * time.Second
+
+// connect dials the server and replaces the current ssh connection
+func (c *Commander) connect() error {
+	client, err := c.dialServer()
+	if err != nil {
+		return err
+	}
+	c.replaceClient(client, true)
+	return nil
+}
+
+// replaceClient switches to the new connection, the old one is closed unless it is left to the actions in flight
+func (c *Commander) replaceClient(client *goph.Client, closeOld bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client != nil && closeOld {
+		c.client.Close()
+	}
+	c.client = client
+	// The server may be another one after reconnected
+	c.capabilities = nil
+}
+
+func (c *Commander) dialServer() (*goph.Client, error) {
+	callback, err := hostKeyCallback(c.config)
+	if err != nil {
+		return nil, err
+	}
+	auth, _ := goph.UseAgent()
+	signer, err := loadIdentity(c.config)
+	if err != nil {
+		return nil, err
+	}
+	if signer != nil {
+		// The identity is offered before the keys of the agent
+		auth = append(goph.Auth{gossh.PublicKeys(signer)}, auth...)
+	}
+	timeout := c.config.ConnectTimeout
+	if timeout == 0 {
+		timeout = goph.DefaultTimeout
+	}
+	sshConfig := &goph.Config{
+		User:     "rsync",
+		Addr:     c.Remote,
+		Port:     c.Port,
+		Auth:     auth,
+		Timeout:  timeout,
+		Callback: callback,
+	}
+	log.Debugf("Connecting to server %v:%d ...", c.Remote, c.Port)
+	return c.dial(sshConfig)
+}
+
+// dial connects like goph.NewConn, and watches the shutdown notice among the global requests of the server
+func (c *Commander) dial(config *goph.Config) (*goph.Client, error) {
+	addr := net.JoinHostPort(config.Addr, fmt.Sprint(config.Port))
+	conn, err := net.DialTimeout("tcp", addr, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := gossh.NewClientConn(conn, addr, &gossh.ClientConfig{
+		User:            config.User,
+		Auth:            config.Auth,
+		Timeout:         config.Timeout,
+		HostKeyCallback: config.Callback,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	forwarded := make(chan *gossh.Request)
+	go func() {
+		defer close(forwarded)
+		for req := range reqs {
+			if req.Type != ShutdownRequest {
+				forwarded <- req
+				continue
+			}
+			log.Infof("[Shutdown] Server %s is shutting down, connect again for the next actions", addr)
+			c.mu.Lock()
+			c.draining = sshConn
+			c.mu.Unlock()
+			if req.WantReply {
+				req.Reply(true, nil)
+			}
+		}
+	}()
+	return &goph.Client{Client: gossh.NewClient(sshConn, chans, forwarded), Config: config}, nil
+}
+
+// serverDraining reports whether the server of the current connection noticed it is shutting down
+func (c *Commander) serverDraining() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.draining != nil && c.client != nil && c.client.Conn == c.draining
+}
+
+func (c *Commander) currentClient() *goph.Client {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client
+}
+
+// newSession opens a new channel on the shared connection, reconnecting once if the connection is gone
+func (c *Commander) newSession() (*gossh.Session, error) {
+	if c.serverDraining() {
+		client, err := c.dialServer()
+		if err == nil {
+			// The actions in flight keep the old connection until the server closes it
+			c.replaceClient(client, false)
+		} else {
+			log.Warnf("[Reconnect] Server is shutting down, connect again failed: %v", err)
+		}
+	}
+	session, err := c.currentClient().NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	log.Warnf("[Reconnect] Open session failed: %v", err)
+	err = c.connect()
+	if err != nil {
+		return nil, err
+	}
+	return c.currentClient().NewSession()
+}
+
+// keepAlive pings the server periodically and reconnects when the connection dropped
+func (c *Commander) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			_, _, err := c.currentClient().SendRequest("keepalive@openssh.com", true, nil)
+			if err == nil {
+				continue
+			}
+			log.Warnf("[Reconnect] Keepalive failed: %v", err)
+			err = c.connect()
+			if err != nil {
+				log.Errorf("[Reconnect] %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	"fmt"
+	"github.com/gliderlabs/ssh"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/term"
+	"io"
+	"sort"
+	"strings"
+)
+
+const shellPrompt = "taokan> "
+
+var shellBuiltins = []Action{
+	{Names: []string{"help"}, Description: "Show this help"},
+	{Names: []string{"history"}, Description: "Show the command history"},
+	{Names: []string{"exit", "quit"}, Description: "Leave the shell"},
+}
+
+// shell is the interactive mode of the sessions with a pty and without a command
+type shell struct {
+	server   *Server
+	caller   Caller
+	terminal *term.Terminal
+	history  []string
+}
+
+func (s *Server) shell(session ssh.Session, caller Caller, windows <-chan ssh.Window) {
+	log.Infof("[Shell] Key: %s opened the shell", caller.Fingerprint)
+	sh := &shell{
+		server:   s,
+		caller:   caller,
+		terminal: term.NewTerminal(session, shellPrompt),
+	}
+	sh.terminal.AutoCompleteCallback = sh.complete
+	go func() {
+		for window := range windows {
+			sh.terminal.SetSize(window.Width, window.Height)
+		}
+	}()
+
+	io.WriteString(sh.terminal, welcomeMsg)
+	io.WriteString(sh.terminal, "Type 'help' to list the actions.\n")
+	for {
+		line, err := sh.terminal.ReadLine()
+		if err != nil {
+			break
+		}
+		commands := strings.Fields(line)
+		if len(commands) == 0 {
+			continue
+		}
+		sh.history = append(sh.history, line)
+
+		switch commands[0] {
+		case "exit", "quit":
+			log.Infof("[Shell] Key: %s closed the shell", caller.Fingerprint)
+			session.Exit(ExitSuccess)
+			return
+		case "help":
+			sh.help()
+		case "history":
+			for i, command := range sh.history {
+				fmt.Fprintf(sh.terminal, "%4d  %s\n", i+1, command)
+			}
+		default:
+			sh.run(commands)
+		}
+	}
+	log.Infof("[Shell] Key: %s closed the shell", caller.Fingerprint)
+	session.Exit(ExitSuccess)
+}
+
+func (sh *shell) run(commands []string) {
+	log.Infof("[Shell] Key: %s Command: `%s`", sh.caller.Fingerprint, strings.Join(commands, " "))
+	err := sh.server.begin()
+	if err == nil {
+		_, err = serverCommandDispatcher(sh.server.commander, sh.caller, sh.terminal, commands)
+		sh.server.inflight.Done()
+	}
+	if err != nil {
+		fmt.Fprintf(sh.terminal, "[Error] %v\n", err)
+		return
+	}
+	io.WriteString(sh.terminal, "\n")
+}
+
+func (sh *shell) help() {
+	for _, action := range append(sh.server.commander.Actions, shellBuiltins...) {
+		fmt.Fprintf(sh.terminal, "  %-70s %s\n", action.usage(), action.Description)
+	}
+}
+
+// complete completes the action name of the first word and the pvc names of the arguments on tab
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	prefix := line[:pos]
+	start := strings.LastIndex(prefix, " ") + 1
+	word := prefix[start:]
+
+	var candidates []string
+	if start == 0 {
+		for _, action := range append(sh.server.commander.Actions, shellBuiltins...) {
+			candidates = append(candidates, action.Names...)
+		}
+	} else {
+		candidates = sh.pvcNames()
+	}
+
+	var matches []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, word) {
+			matches = append(matches, candidate)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", 0, false
+	case 1:
+		completed := matches[0] + " "
+		return prefix[:start] + completed + line[pos:], start + len(completed), true
+	}
+
+	sort.Strings(matches)
+	fmt.Fprintf(sh.terminal, "%s\n", strings.Join(matches, "  "))
+	common := commonPrefix(matches)
+	return prefix[:start] + common + line[pos:], start + len(common), true
+}
+
+func (sh *shell) pvcNames() []string {
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	pvcs, err := k8s.ListPvc(Namespace)
+	if err != nil {
+		log.Warnf("[Shell] List pvc failed: %v", err)
+		return nil
+	}
+	var names []string
+	for _, pvc := range pvcs {
+		names = append(names, pvc.Name)
+	}
+	return names
+}
+
+func commonPrefix(values []string) string {
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
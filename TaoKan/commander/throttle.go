@@ -0,0 +1,125 @@
+package commander
+
+import (
+	KubernetesAPI "TaoKan/k8s"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+	v1 "k8s.io/api/core/v1"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRateBurst = 10
+	// mountRetryAfter is the hint to retry the mount throttled by the limit of the in-flight mounts
+	mountRetryAfter = 30 * time.Second
+)
+
+// throttler limits the request rate of each key and the rsync-server pods mounting the pvcs.
+// The zero limits are unlimited.
+type throttler struct {
+	rate      rate.Limit
+	burst     int
+	maxMounts int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+
+	mountMu sync.Mutex
+	// launching are the reserved rsync-server pods by "namespace/name"
+	launching map[string]bool
+}
+
+var throttle = newThrottler(0, DefaultRateBurst, 0)
+
+func newThrottler(requestRate float64, burst int, maxMounts int) *throttler {
+	if burst <= 0 {
+		burst = DefaultRateBurst
+	}
+	return &throttler{
+		rate:      rate.Limit(requestRate),
+		burst:     burst,
+		maxMounts: maxMounts,
+		limiters:  map[string]*rate.Limiter{},
+		launching: map[string]bool{},
+	}
+}
+
+// allow takes a request of the key from its rate limit
+func (t *throttler) allow(key string) error {
+	if t.rate <= 0 {
+		return nil
+	}
+	t.mu.Lock()
+	limiter, ok := t.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(t.rate, t.burst)
+		t.limiters[key] = limiter
+	}
+	t.mu.Unlock()
+
+	reservation := limiter.Reserve()
+	delay := reservation.Delay()
+	if delay == 0 {
+		return nil
+	}
+	reservation.Cancel()
+	log.Warnf("[Throttled] Key: %s exceeded %v requests per second", key, float64(t.rate))
+	return &Error{
+		Code:       ErrCodeThrottled,
+		Message:    "too many requests, retry later",
+		RetryAfter: delay,
+	}
+}
+
+// reserveMount counts the rsync-server pods in the cluster, and reserves a slot for the pod of the pvc to launch.
+// The returned func releases the slot once the pod is launched or failed.
+func (t *throttler) reserveMount(namespace string, pvcName string) (func(), error) {
+	if t.maxMounts <= 0 {
+		return func() {}, nil
+	}
+	t.mountMu.Lock()
+	defer t.mountMu.Unlock()
+
+	k8s := KubernetesAPI.GetInstance(KubeConfig)
+	pods, err := k8s.ListRsyncServerPods("")
+	if err != nil {
+		return nil, err
+	}
+	mounts := countMounts(pods, t.launching)
+	if mounts >= t.maxMounts {
+		log.Warnf("[Throttled] %d in-flight mounts reached the limit %d", mounts, t.maxMounts)
+		return nil, &Error{
+			Code:       ErrCodeThrottled,
+			Message:    "too many pvcs mounted, retry later",
+			RetryAfter: mountRetryAfter,
+		}
+	}
+
+	key := namespace + "/rsync-server-" + pvcName
+	t.launching[key] = true
+	return func() {
+		t.mountMu.Lock()
+		delete(t.launching, key)
+		t.mountMu.Unlock()
+	}, nil
+}
+
+// countMounts counts the live pods and the launching ones not listed yet, the pod created by a reservation
+// is listed before its reservation is released
+func countMounts(pods []v1.Pod, launching map[string]bool) int {
+	mounts := 0
+	listed := map[string]bool{}
+	for _, pod := range pods {
+		if pod.DeletionTimestamp == nil {
+			listed[pod.Namespace+"/"+pod.Name] = true
+			mounts++
+		}
+	}
+	for key := range launching {
+		if !listed[key] {
+			mounts++
+		}
+	}
+	return mounts
+}
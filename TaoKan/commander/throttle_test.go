@@ -0,0 +1,39 @@
+package commander
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestCountMounts(t *testing.T) {
+	pod := func(namespace string, name string, deleting bool) v1.Pod {
+		pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if deleting {
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+		}
+		return pod
+	}
+	tests := []struct {
+		name      string
+		pods      []v1.Pod
+		launching map[string]bool
+		want      int
+	}{
+		{"none", nil, nil, 0},
+		{"listed pods", []v1.Pod{pod("hub", "rsync-server-a", false), pod("tenant-a", "rsync-server-b", false)}, nil, 2},
+		{"deleting pods", []v1.Pod{pod("hub", "rsync-server-a", true)}, nil, 0},
+		{"launching not listed", []v1.Pod{pod("hub", "rsync-server-a", false)}, map[string]bool{"hub/rsync-server-b": true}, 2},
+		{"launching listed", []v1.Pod{pod("hub", "rsync-server-a", false)}, map[string]bool{"hub/rsync-server-a": true}, 1},
+		{"launching in other namespace", []v1.Pod{pod("hub", "rsync-server-a", false)}, map[string]bool{"tenant-a/rsync-server-a": true}, 2},
+		{"launching over deleting pod", []v1.Pod{pod("hub", "rsync-server-a", true)}, map[string]bool{"hub/rsync-server-a": true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countMounts(tt.pods, tt.launching); got != tt.want {
+				t.Errorf("countMounts() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
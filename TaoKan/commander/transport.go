@@ -0,0 +1,151 @@
+package commander
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type TransportType string
+
+const (
+	SSHTransport   TransportType = "ssh"
+	HTTPSTransport TransportType = "https"
+)
+
+// Transport carries the action calls of the client to the server
+type Transport interface {
+	// Call gives up once the context is done
+	Call(ctx context.Context, command string, args []string) (*Response, error)
+	// Reset drops the current connection, the next call connects to the server again
+	Reset() error
+	Close()
+}
+
+// sshTransport calls the actions over the shared ssh connection of the commander
+type sshTransport struct {
+	c    *Commander
+	once sync.Once
+}
+
+func (t *sshTransport) Call(ctx context.Context, command string, args []string) (*Response, error) {
+	return clientCallDispatcher(ctx, t.c, command, args)
+}
+
+func (t *sshTransport) Reset() error {
+	return t.c.connect()
+}
+
+func (t *sshTransport) Close() {
+	t.once.Do(func() {
+		close(t.c.done)
+		t.c.currentClient().Close()
+		log.Debugf("Closed ssh connection")
+	})
+}
+
+// httpsTransport calls the actions over the http api of the server
+type httpsTransport struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+func newHTTPSTransport(config Config) (*httpsTransport, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if config.ServerCAFile != "" {
+		content, err := os.ReadFile(config.ServerCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return nil, fmt.Errorf("no certificate found in %s", config.ServerCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.TLSCertFile != "" || config.TLSKeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	transport := &httpsTransport{
+		baseURL: fmt.Sprintf("https://%s:%d", config.Remote, config.Port),
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+	if config.APITokenFile != "" {
+		content, err := os.ReadFile(config.APITokenFile)
+		if err != nil {
+			return nil, err
+		}
+		transport.token = strings.TrimSpace(string(content))
+	}
+	if transport.token == "" && len(tlsConfig.Certificates) == 0 {
+		return nil, fmt.Errorf("the https transport requires the api token or the client certificate")
+	}
+	return transport, nil
+}
+
+func (t *httpsTransport) Call(ctx context.Context, command string, args []string) (*Response, error) {
+	log.Debugf("[Call] Command: `%s`", command)
+	body, err := json.Marshal(ActionRequest{Args: args})
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+apiActionsPath+command, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", ndjsonContentType)
+	if t.token != "" {
+		request.Header.Set("Authorization", "Bearer "+t.token)
+	}
+
+	httpResponse, err := t.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResponse.Body.Close()
+	if httpResponse.StatusCode == http.StatusUnauthorized {
+		return nil, newError(ErrCodeForbidden, "unauthorized by the server")
+	}
+
+	outBytes, err := streamProgress(httpResponse.Body, progressTrackerOf(ctx))
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+	response, err := parseResponse(outBytes)
+	if err != nil {
+		return nil, newError(ErrCodeInternal, "http status %d: %s", httpResponse.StatusCode, strings.TrimSpace(string(outBytes)))
+	}
+	return response, response.Err()
+}
+
+func (t *httpsTransport) Reset() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+func (t *httpsTransport) Close() {
+	t.client.CloseIdleConnections()
+}
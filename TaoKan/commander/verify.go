@@ -0,0 +1,127 @@
+package commander
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DataMountPath is where the rsync pods mount the pvc
+const DataMountPath = "/data"
+
+// ManifestEntry describes a regular file of the pvc, the path is relative to the mount path
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"modTime"`
+	Sha256  string `json:"sha256,omitempty"`
+}
+
+type Manifest struct {
+	Pvc     string          `json:"pvc"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+type ManifestMismatch struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ManifestDiff is the difference between the source and the remote manifest
+type ManifestDiff struct {
+	Missing    []string           `json:"missing,omitempty"`
+	Extra      []string           `json:"extra,omitempty"`
+	Mismatched []ManifestMismatch `json:"mismatched,omitempty"`
+}
+
+// ManifestCommand returns the command to list the files of the pvc in the rsync pods.
+// The rsync logs copied by the rsync-worker into the remote pvc are excluded.
+func ManifestCommand(checksum bool) []string {
+	script := fmt.Sprintf("cd %s && find . -path ./backup_log -prune -o -path ./rsync-verifier -prune -o -type f -printf '%%P\\t%%s\\t%%T@\\n'", DataMountPath)
+	if checksum {
+		script = fmt.Sprintf("cd %s && find . -path ./backup_log -prune -o -path ./rsync-verifier -prune -o -type f -printf '%%P\\t%%s\\t%%T@\\t' -exec sha256sum {} \\;", DataMountPath)
+	}
+	return []string{"/bin/bash", "-c", script}
+}
+
+// ParseManifest parses the output of the ManifestCommand
+func ParseManifest(output string) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid manifest line: %s", line)
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of %s: %v", fields[0], err)
+		}
+		// Compare the modification time in seconds, the precision differs between file systems
+		modTime, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modification time of %s: %v", fields[0], err)
+		}
+		entry := ManifestEntry{
+			Path:    fields[0],
+			Size:    size,
+			ModTime: int64(modTime),
+		}
+		if len(fields) > 3 {
+			if checksum := strings.Fields(fields[3]); len(checksum) > 0 {
+				entry.Sha256 = checksum[0]
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// DiffManifest compares the files of the source pvc with the ones of the remote pvc
+func DiffManifest(source []ManifestEntry, remote []ManifestEntry) ManifestDiff {
+	var diff ManifestDiff
+	remoteEntries := map[string]ManifestEntry{}
+	for _, entry := range remote {
+		remoteEntries[entry.Path] = entry
+	}
+
+	for _, entry := range source {
+		remoteEntry, ok := remoteEntries[entry.Path]
+		if !ok {
+			diff.Missing = append(diff.Missing, entry.Path)
+			continue
+		}
+		delete(remoteEntries, entry.Path)
+
+		switch {
+		case entry.Size != remoteEntry.Size:
+			diff.Mismatched = append(diff.Mismatched, ManifestMismatch{
+				Path:   entry.Path,
+				Reason: fmt.Sprintf("size %d != %d", entry.Size, remoteEntry.Size),
+			})
+		case entry.ModTime != remoteEntry.ModTime:
+			diff.Mismatched = append(diff.Mismatched, ManifestMismatch{
+				Path:   entry.Path,
+				Reason: fmt.Sprintf("mtime %d != %d", entry.ModTime, remoteEntry.ModTime),
+			})
+		case entry.Sha256 != "" && remoteEntry.Sha256 != "" && entry.Sha256 != remoteEntry.Sha256:
+			diff.Mismatched = append(diff.Mismatched, ManifestMismatch{
+				Path:   entry.Path,
+				Reason: "sha256 mismatch",
+			})
+		}
+	}
+	for path := range remoteEntries {
+		diff.Extra = append(diff.Extra, path)
+	}
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Extra)
+	return diff
+}
+
+func (d ManifestDiff) Diverged() bool {
+	return len(d.Missing) > 0 || len(d.Extra) > 0 || len(d.Mismatched) > 0
+}
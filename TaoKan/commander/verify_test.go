@@ -0,0 +1,113 @@
+package commander
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffManifest(t *testing.T) {
+	file := func(path string, size int64, modTime int64, sha256 string) ManifestEntry {
+		return ManifestEntry{Path: path, Size: size, ModTime: modTime, Sha256: sha256}
+	}
+	tests := []struct {
+		name     string
+		source   []ManifestEntry
+		remote   []ManifestEntry
+		want     ManifestDiff
+		diverged bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:   "identical",
+			source: []ManifestEntry{file("a", 1, 100, "x"), file("b/c", 2, 200, "")},
+			remote: []ManifestEntry{file("b/c", 2, 200, ""), file("a", 1, 100, "x")},
+		},
+		{
+			name:     "missing and extra are sorted",
+			source:   []ManifestEntry{file("z", 1, 100, ""), file("a", 1, 100, ""), file("same", 1, 100, "")},
+			remote:   []ManifestEntry{file("same", 1, 100, ""), file("y", 1, 100, ""), file("b", 1, 100, "")},
+			want:     ManifestDiff{Missing: []string{"a", "z"}, Extra: []string{"b", "y"}},
+			diverged: true,
+		},
+		{
+			name:     "size before mtime",
+			source:   []ManifestEntry{file("a", 1, 100, "")},
+			remote:   []ManifestEntry{file("a", 2, 200, "")},
+			want:     ManifestDiff{Mismatched: []ManifestMismatch{{Path: "a", Reason: "size 1 != 2"}}},
+			diverged: true,
+		},
+		{
+			name:     "mtime",
+			source:   []ManifestEntry{file("a", 1, 100, "")},
+			remote:   []ManifestEntry{file("a", 1, 101, "")},
+			want:     ManifestDiff{Mismatched: []ManifestMismatch{{Path: "a", Reason: "mtime 100 != 101"}}},
+			diverged: true,
+		},
+		{
+			name:     "checksum",
+			source:   []ManifestEntry{file("a", 1, 100, "x")},
+			remote:   []ManifestEntry{file("a", 1, 100, "y")},
+			want:     ManifestDiff{Mismatched: []ManifestMismatch{{Path: "a", Reason: "sha256 mismatch"}}},
+			diverged: true,
+		},
+		{
+			name:   "checksum on one side only",
+			source: []ManifestEntry{file("a", 1, 100, "x")},
+			remote: []ManifestEntry{file("a", 1, 100, "")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffManifest(tt.source, tt.remote)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffManifest() = %+v, want %+v", got, tt.want)
+			}
+			if got.Diverged() != tt.diverged {
+				t.Errorf("Diverged() = %v, want %v", got.Diverged(), tt.diverged)
+			}
+		})
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []ManifestEntry
+		wantErr bool
+	}{
+		{
+			name:   "without checksum",
+			output: "a\t1\t100.250000\nb/c d\t0\t200.0\n",
+			want:   []ManifestEntry{{Path: "a", Size: 1, ModTime: 100}, {Path: "b/c d", Size: 0, ModTime: 200}},
+		},
+		{
+			name:   "with checksum",
+			output: "a\t1\t100.5\tabc  ./a\n",
+			want:   []ManifestEntry{{Path: "a", Size: 1, ModTime: 100, Sha256: "abc"}},
+		},
+		{
+			name:    "missing fields",
+			output:  "a\t1\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid size",
+			output:  "a\tbig\t100\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseManifest(tt.output)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseManifest() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,22 +1,28 @@
 package KubernetesAPI
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	coordinationv1 "k8s.io/api/coordination/v1"
 	v1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
 	watchTool "k8s.io/client-go/tools/watch"
 	"os"
 	"strings"
@@ -26,6 +32,21 @@ import (
 
 var lock = &sync.Mutex{}
 
+// ErrPodLaunchTimeout is wrapped by the errors of pods which fail to start in time
+var ErrPodLaunchTimeout = errors.New("pod launch timeout")
+
+// ErrCannotExpand is wrapped by the errors of pvcs which need to grow but cannot be expanded
+var ErrCannotExpand = errors.New("cannot expand")
+
+// ErrPvcResizeTimeout is wrapped by the errors of pvcs which fail to finish resizing in time
+var ErrPvcResizeTimeout = errors.New("pvc resize timeout")
+
+// ErrLeaseHeld is wrapped by the errors of leases which are held by another holder
+var ErrLeaseHeld = errors.New("lease is held by another holder")
+
+// ProgressFunc receives the progress of the long-running operations, e.g. launching a pod
+type ProgressFunc func(stage string, message string)
+
 type storageClass struct {
 	rwo string
 	rwx string
@@ -33,6 +54,7 @@ type storageClass struct {
 type KubernetesCluster struct {
 	Clientset *kubernetes.Clientset
 
+	restConfig          *rest.Config
 	defaultStorageClass storageClass
 }
 
@@ -44,6 +66,14 @@ const (
 	DatasetDataPvcPrefix  string = "data-nfs-dataset-"
 	ProjectDataPvcPostfix string = "-0"
 	DatasetDataPvcPostfix string = "-0"
+
+	// TaoKanAnnotationPrefix is the prefix of the annotations TaoKan puts on the resources
+	TaoKanAnnotationPrefix string = "taokan.infuseai.io/"
+	// PvcTypeLabel records the type of the pvc created by TaoKan
+	PvcTypeLabel string = TaoKanAnnotationPrefix + "pvc-type"
+
+	// RsyncSshKeySecret is the secret of the ssh key pair used by the rsync pods
+	RsyncSshKeySecret string = "rsync-ssh-key"
 )
 
 var instance *KubernetesCluster
@@ -85,6 +115,7 @@ func (k *KubernetesCluster) init(kubeconfig string) error {
 		if err != nil {
 			return err
 		}
+		k.restConfig = config
 		return nil
 	} else {
 		// creates the in-cluster config
@@ -97,6 +128,7 @@ func (k *KubernetesCluster) init(kubeconfig string) error {
 		if err != nil {
 			return err
 		}
+		k.restConfig = config
 	}
 
 	return nil
@@ -110,10 +142,256 @@ func (k *KubernetesCluster) SetRwxStorageClass(storageClass string) {
 	k.defaultStorageClass.rwx = storageClass
 }
 
+func (k *KubernetesCluster) GetSecret(namespace string, name string) (*v1.Secret, error) {
+	return k.Clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+func (k *KubernetesCluster) CreateSecret(namespace string, name string, data map[string][]byte) error {
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"managed-by": "TaoKan"},
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: data,
+	}
+	_, err := k.Clientset.CoreV1().Secrets(namespace).Create(context.TODO(), secret, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	log.Infof("[Created] Secret: %s", name)
+	return nil
+}
+
+// CopySecret copies the secret to another namespace if it does not exist there
+func (k *KubernetesCluster) CopySecret(fromNamespace string, toNamespace string, name string) error {
+	_, err := k.GetSecret(toNamespace, name)
+	if err == nil {
+		return nil
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	secret, err := k.GetSecret(fromNamespace, name)
+	if err != nil {
+		return err
+	}
+	copied := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: toNamespace,
+			Labels:    map[string]string{"managed-by": "TaoKan"},
+		},
+		Type: secret.Type,
+		Data: secret.Data,
+	}
+	_, err = k.Clientset.CoreV1().Secrets(toNamespace).Create(context.TODO(), copied, metav1.CreateOptions{})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	log.Infof("[Created] Secret: %s/%s", toNamespace, name)
+	return nil
+}
+
+// EnsureNamespace creates the namespace if it does not exist
+func (k *KubernetesCluster) EnsureNamespace(name string) error {
+	ctx := context.TODO()
+	_, err := k.Clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !k8sErrors.IsNotFound(err) {
+		return err
+	}
+	namespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{"managed-by": "TaoKan"},
+		},
+	}
+	_, err = k.Clientset.CoreV1().Namespaces().Create(ctx, namespace, metav1.CreateOptions{})
+	if err != nil && !k8sErrors.IsAlreadyExists(err) {
+		return err
+	}
+	log.Infof("[Created] Namespace: %s", name)
+	return nil
+}
+
 func (k *KubernetesCluster) GetConfigMap(namespace string, name string) (*v1.ConfigMap, error) {
 	return k.Clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
 }
 
+// ApplyConfigMap creates the config map or merges the data into the existing one
+func (k *KubernetesCluster) ApplyConfigMap(namespace string, name string, data map[string]string) error {
+	ctx := context.TODO()
+	configMap, err := k.GetConfigMap(namespace, name)
+	if k8sErrors.IsNotFound(err) {
+		configMap = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"managed-by": "TaoKan"},
+			},
+			Data: data,
+		}
+		_, err = k.Clientset.CoreV1().ConfigMaps(namespace).Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	for key, value := range data {
+		configMap.Data[key] = value
+	}
+	_, err = k.Clientset.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// RemoveConfigMapKeys removes the keys from the config map if it exists
+func (k *KubernetesCluster) RemoveConfigMapKeys(namespace string, name string, keys ...string) error {
+	configMap, err := k.GetConfigMap(namespace, name)
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	removed := false
+	for _, key := range keys {
+		if _, ok := configMap.Data[key]; ok {
+			delete(configMap.Data, key)
+			removed = true
+		}
+	}
+	if !removed {
+		return nil
+	}
+	_, err = k.Clientset.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return err
+}
+
+// CreateEvent records an event of the object in the namespace
+func (k *KubernetesCluster) CreateEvent(namespace string, kind string, name string, eventType string, reason string, message string) error {
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: strings.ToLower(name) + ".",
+			Namespace:    namespace,
+		},
+		InvolvedObject: v1.ObjectReference{
+			Kind:      kind,
+			Namespace: namespace,
+			Name:      name,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Source:         v1.EventSource{Component: "TaoKan"},
+	}
+	_, err := k.Clientset.CoreV1().Events(namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+	return err
+}
+
+// AcquireLease takes the lease for the holder, the lease held by another holder is taken over only after it expired
+func (k *KubernetesCluster) AcquireLease(namespace string, name string, holder string, duration time.Duration) error {
+	ctx := context.TODO()
+	leases := k.Clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(duration.Seconds())
+	spec := coordinationv1.LeaseSpec{
+		HolderIdentity:       &holder,
+		LeaseDurationSeconds: &seconds,
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{"managed-by": "TaoKan"},
+			},
+			Spec: spec,
+		}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		if k8sErrors.IsAlreadyExists(err) {
+			return fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	current := lease.Spec
+	if current.HolderIdentity != nil && *current.HolderIdentity != holder && current.RenewTime != nil && current.LeaseDurationSeconds != nil {
+		expiry := current.RenewTime.Add(time.Duration(*current.LeaseDurationSeconds) * time.Second)
+		if time.Now().Before(expiry) {
+			return fmt.Errorf("%w: %s is held by %s", ErrLeaseHeld, name, *current.HolderIdentity)
+		}
+		log.Warnf("[Lease] Take over the expired lease %s from %s", name, *current.HolderIdentity)
+	}
+	lease.Spec = spec
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		return fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+	}
+	return err
+}
+
+// RenewLease extends the lease held by the holder, it fails with ErrLeaseHeld if the lease was taken over
+func (k *KubernetesCluster) RenewLease(namespace string, name string, holder string) error {
+	ctx := context.TODO()
+	leases := k.Clientset.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return fmt.Errorf("%w: %s is taken over", ErrLeaseHeld, name)
+	}
+	now := metav1.NewMicroTime(time.Now())
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	if k8sErrors.IsConflict(err) {
+		return fmt.Errorf("%w: %s", ErrLeaseHeld, name)
+	}
+	return err
+}
+
+// ReleaseLease deletes the lease if it is held by the holder
+func (k *KubernetesCluster) ReleaseLease(namespace string, name string, holder string) error {
+	ctx := context.TODO()
+	leases := k.Clientset.CoordinationV1().Leases(namespace)
+	lease, err := leases.Get(ctx, name, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return nil
+	}
+	err = leases.Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &lease.ResourceVersion},
+	})
+	if k8sErrors.IsNotFound(err) || k8sErrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
 func (k *KubernetesCluster) ListPods(namespace string) ([]v1.Pod, error) {
 	podList, err := k.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
@@ -122,6 +400,17 @@ func (k *KubernetesCluster) ListPods(namespace string) ([]v1.Pod, error) {
 	return podList.Items, err
 }
 
+// ListRsyncServerPods lists the rsync-server pods launched by TaoKan, in all namespaces if the namespace is empty
+func (k *KubernetesCluster) ListRsyncServerPods(namespace string) ([]v1.Pod, error) {
+	podList, err := k.Clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "role=rsync-server,managed-by=TaoKan",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
 func (k *KubernetesCluster) ListPodsByFilter(namespace string, predicate func(pod v1.Pod) bool) ([]v1.Pod, error) {
 	nsPods, err := k.ListPods(namespace)
 	if err != nil {
@@ -153,6 +442,34 @@ func (k *KubernetesCluster) GetPod(namespace string, podName string) (*v1.Pod, e
 	return k.Clientset.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 }
 
+// ExecInPod runs the command in the first container of the pod and returns its stdout
+func (k *KubernetesCluster) ExecInPod(namespace string, podName string, command []string) (string, error) {
+	request := k.Clientset.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Command: command,
+			Stdout:  true,
+			Stderr:  true,
+		}, scheme.ParameterCodec)
+
+	executor, err := remotecommand.NewSPDYExecutor(k.restConfig, "POST", request.URL())
+	if err != nil {
+		return "", err
+	}
+	var stdout, stderr bytes.Buffer
+	err = executor.Stream(remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("exec in pod %s: %v: %s", podName, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
 func (k *KubernetesCluster) DeletePod(namespace string, podName string) error {
 	ctx := context.TODO()
 
@@ -202,6 +519,24 @@ func (k *KubernetesCluster) GetPvc(namespace string, pvcName string) (*v1.Persis
 	return pvc, usedPods, err
 }
 
+// FindPvc returns the pvc, nil if it does not exist
+func (k *KubernetesCluster) FindPvc(namespace string, pvcName string) (*v1.PersistentVolumeClaim, error) {
+	pvc, err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+	if k8sErrors.IsNotFound(err) {
+		return nil, nil
+	}
+	return pvc, err
+}
+
+func (k *KubernetesCluster) DeletePvc(namespace string, pvcName string) error {
+	err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+	if err != nil {
+		return err
+	}
+	log.Warnf("[Deleted] pvc: %s", pvcName)
+	return nil
+}
+
 func (k *KubernetesCluster) ListPvc(namespace string) ([]v1.PersistentVolumeClaim, error) {
 	pvcList, err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
 	if err != nil {
@@ -210,6 +545,25 @@ func (k *KubernetesCluster) ListPvc(namespace string) ([]v1.PersistentVolumeClai
 	return pvcList.Items, nil
 }
 
+// ListTaoKanPvcs lists the pvcs created by TaoKan, in all namespaces if the namespace is empty
+func (k *KubernetesCluster) ListTaoKanPvcs(namespace string) ([]v1.PersistentVolumeClaim, error) {
+	pvcList, err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "managed-by=TaoKan",
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pvcList.Items, nil
+}
+
+func (k *KubernetesCluster) ListResourceQuotas(namespace string) ([]v1.ResourceQuota, error) {
+	quotaList, err := k.Clientset.CoreV1().ResourceQuotas(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return quotaList.Items, nil
+}
+
 func (k *KubernetesCluster) ListPvcByFilter(namespace string, predicate func(pvc v1.PersistentVolumeClaim) bool) ([]v1.PersistentVolumeClaim, error) {
 	pvcs, err := k.ListPvc(namespace)
 	if err != nil {
@@ -255,26 +609,6 @@ func (k *KubernetesCluster) ListDatasetDataPvc(namespace string) ([]v1.Persisten
 	})
 }
 
-func (k *KubernetesCluster) ShowPvcStatus(namespace string, pvcs []v1.PersistentVolumeClaim) (string, error) {
-	var content string
-
-	for _, pvc := range pvcs {
-		content += fmt.Sprintf("  %s\n", pvc.Name)
-		pods, err := k.ListPodsUsePvc(namespace, pvc.Name)
-		if err != nil {
-			return "", err
-		}
-		if len(pods) > 0 {
-			content += "    Used by: "
-			for _, pod := range pods {
-				content += fmt.Sprintf("%s ", pod.Name)
-			}
-			content += "\n"
-		}
-	}
-	return content, nil
-}
-
 func parseContainerStatus(pod *v1.Pod) (status string, reason string, message string, restartCount int32) {
 	if len(pod.Status.ContainerStatuses) > 0 {
 		containerState := pod.Status.ContainerStatuses[0].State
@@ -299,7 +633,12 @@ func parseContainerStatus(pod *v1.Pod) (status string, reason string, message st
 //go:embed rsync-server.yaml
 var RsyncServerYamlTemplate []byte
 
-func (k *KubernetesCluster) LaunchRsyncServerPod(namespace string, pvcName string) error {
+// LaunchRsyncServerPod starts the rsync-server pod of the pvc and waits until it is running,
+// the progress is reported to the optional progress func
+func (k *KubernetesCluster) LaunchRsyncServerPod(namespace string, pvcName string, progress ProgressFunc) error {
+	if progress == nil {
+		progress = func(stage string, message string) {}
+	}
 	var podTemplate v1.Pod
 	err := yaml.Unmarshal(RsyncServerYamlTemplate, &podTemplate)
 	if err != nil {
@@ -323,6 +662,10 @@ func (k *KubernetesCluster) LaunchRsyncServerPod(namespace string, pvcName strin
 
 	// Apply pod
 	pod, err := k.Clientset.CoreV1().Pods(namespace).Create(context.TODO(), &podTemplate, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	progress("pod-created", pod.Name)
 
 	// Check Service
 	retryTimes := 3
@@ -341,9 +684,10 @@ func (k *KubernetesCluster) LaunchRsyncServerPod(namespace string, pvcName strin
 		return err
 	}
 	log.Infof("Service %s found", svc.Name)
+	progress("service-found", svc.Name)
 
 	// Wait until rsync-server pod ready
-	err = k.WatchPod(*pod, v1.PodRunning, 0)
+	err = k.watchPod(*pod, v1.PodRunning, 0, progress)
 	if err != nil {
 		return err
 	}
@@ -354,7 +698,58 @@ func (k *KubernetesCluster) LaunchRsyncServerPod(namespace string, pvcName strin
 //go:embed rsync-worker.yaml
 var RsyncWorkerYamlTemplate []byte
 
-func (k *KubernetesCluster) LaunchRsyncWorkerPod(remote string, namespace string, pvcName string, podRetryTimes int32) error {
+// LaunchRsyncVerifierPod starts an idle pod mounting the pvc to inspect its data
+func (k *KubernetesCluster) LaunchRsyncVerifierPod(namespace string, pvcName string) (*v1.Pod, error) {
+	var podTemplate v1.Pod
+	err := yaml.Unmarshal(RsyncWorkerYamlTemplate, &podTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	podTemplate.Name = fmt.Sprintf("rsync-verifier-%s", pvcName)
+	podTemplate.Namespace = namespace
+	podTemplate.Labels["app"] = "rsync-verifier"
+	podTemplate.Labels["role"] = "rsync-verifier"
+	podTemplate.Labels["mountPvc"] = pvcName
+	podTemplate.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = pvcName
+	podTemplate.Spec.Containers[0].Command = []string{"sleep", "infinity"}
+	podTemplate.Spec.Containers[0].Env = nil
+	podTemplate.Spec.RestartPolicy = v1.RestartPolicyNever
+
+	// Add registry as the prefix of image name
+	registry := strings.TrimRight(viper.GetString("registry"), "/")
+	imageName := strings.Split(podTemplate.Spec.Containers[0].Image, ":")[0]
+	imageTag := viper.GetString("image-tag")
+	podTemplate.Spec.Containers[0].Image = fmt.Sprintf("%s/%s:%s", registry, imageName, imageTag)
+	if viper.GetString("image-pull-policy") == string(v1.PullIfNotPresent) {
+		podTemplate.Spec.Containers[0].ImagePullPolicy = v1.PullIfNotPresent
+	}
+
+	// Delete the existing pod
+	err = k.DeletePod(namespace, podTemplate.Name)
+	if err != nil {
+		log.Warn(err)
+	}
+
+	pod, err := k.Clientset.CoreV1().Pods(namespace).Create(context.TODO(), &podTemplate, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("[Created] Pod: %s", podTemplate.Name)
+
+	err = k.WatchPod(*pod, v1.PodRunning, 0)
+	if err != nil {
+		return nil, err
+	}
+	return pod, nil
+}
+
+// LaunchRsyncWorkerPod launches the pod syncing the pvc to the rsync-server pod in the remote namespace,
+// which is the same as the local namespace if empty
+func (k *KubernetesCluster) LaunchRsyncWorkerPod(remote string, namespace string, remoteNamespace string, pvcName string, podRetryTimes int32) error {
+	if remoteNamespace == "" {
+		remoteNamespace = namespace
+	}
 	var podTemplate v1.Pod
 	err := yaml.Unmarshal(RsyncWorkerYamlTemplate, &podTemplate)
 	if err != nil {
@@ -372,7 +767,7 @@ func (k *KubernetesCluster) LaunchRsyncWorkerPod(remote string, namespace string
 		case "REMOTE_SERVER_NAME":
 			podTemplate.Spec.Containers[0].Env[i].Value = fmt.Sprintf("rsync-server-%s", pvcName)
 		case "REMOTE_NAMESPACE":
-			podTemplate.Spec.Containers[0].Env[i].Value = namespace
+			podTemplate.Spec.Containers[0].Env[i].Value = remoteNamespace
 		case "REMOTE_PVC_NAME":
 			podTemplate.Spec.Containers[0].Env[i].Value = pvcName
 		}
@@ -416,6 +811,25 @@ func (k *KubernetesCluster) LaunchRsyncWorkerPod(remote string, namespace string
 }
 
 func (k *KubernetesCluster) WatchPod(podTemplate v1.Pod, watchUntil v1.PodPhase, podRetryTimes int32) error {
+	return k.watchPod(podTemplate, watchUntil, podRetryTimes, func(stage string, message string) {})
+}
+
+// podStage describes where the pod is in its startup for the progress report
+func podStage(pod *v1.Pod, status string, reason string) (string, string) {
+	switch {
+	case pod.Status.Phase == v1.PodPending && pod.Spec.NodeName == "":
+		return "pod-pending", "waiting to be scheduled"
+	case pod.Status.Phase == v1.PodPending && reason == "ContainerCreating":
+		return "container-creating", fmt.Sprintf("pulling image %s on node %s", pod.Spec.Containers[0].Image, pod.Spec.NodeName)
+	case pod.Status.Phase == v1.PodPending:
+		return "pod-scheduled", "node " + pod.Spec.NodeName
+	case status == "Running":
+		return "container-running", pod.Name
+	}
+	return strings.ToLower(string(pod.Status.Phase)), strings.TrimSpace(status + " " + reason)
+}
+
+func (k *KubernetesCluster) watchPod(podTemplate v1.Pod, watchUntil v1.PodPhase, podRetryTimes int32, progress ProgressFunc) error {
 	ctx := context.TODO()
 	selector := "metadata.name=" + podTemplate.Name
 	watchFunc := func(options metav1.ListOptions) (watch.Interface, error) {
@@ -434,6 +848,7 @@ func (k *KubernetesCluster) WatchPod(podTemplate v1.Pod, watchUntil v1.PodPhase,
 	}
 
 	startTime := time.Now()
+	lastStage := ""
 	for {
 		select {
 		case e, ok := <-watcher.ResultChan():
@@ -447,6 +862,10 @@ func (k *KubernetesCluster) WatchPod(podTemplate v1.Pod, watchUntil v1.PodPhase,
 			phase := pod.Status.Phase
 			status, reason, msg, restartCount := parseContainerStatus(pod)
 			log.Debugf("Pod: %s Phase: %v status: %v:%v", pod.Name, pod.Status.Phase, status, reason)
+			if stage, message := podStage(pod, status, reason); stage+message != lastStage {
+				lastStage = stage + message
+				progress(stage, message)
+			}
 			switch phase {
 			case v1.PodPending:
 				if msg != "" {
@@ -455,7 +874,7 @@ func (k *KubernetesCluster) WatchPod(podTemplate v1.Pod, watchUntil v1.PodPhase,
 				currentTime := time.Now()
 				duration := currentTime.Sub(startTime)
 				if duration >= time.Minute*5 {
-					return fmt.Errorf("[%v] Pod: %s abort due to pending timeout (5 mins)", status, pod.Name)
+					return fmt.Errorf("[%v] Pod: %s abort due to pending timeout (5 mins): %w", status, pod.Name, ErrPodLaunchTimeout)
 				}
 				continue
 
@@ -577,7 +996,7 @@ var UserPvcTemplate []byte
 //go:embed volume-pvc-template.yaml
 var VolumePvcTemplate []byte
 
-func (k *KubernetesCluster) CreatePvc(pvcTemplate v1.PersistentVolumeClaim) error {
+func (k *KubernetesCluster) CreatePvc(pvcType string, pvcTemplate v1.PersistentVolumeClaim) error {
 
 	if pvcTemplate.Spec.AccessModes[0] == v1.ReadWriteMany && k.defaultStorageClass.rwx != "" {
 		log.Debugf("Set RWX stroage class: %s", k.defaultStorageClass.rwx)
@@ -587,11 +1006,18 @@ func (k *KubernetesCluster) CreatePvc(pvcTemplate v1.PersistentVolumeClaim) erro
 		pvcTemplate.Spec.StorageClassName = &k.defaultStorageClass.rwo
 	}
 
+	// Mark the pvc created by TaoKan with its type, only those pvcs can be purged
+	if pvcTemplate.Labels == nil {
+		pvcTemplate.Labels = map[string]string{}
+	}
+	pvcTemplate.Labels["managed-by"] = "TaoKan"
+	pvcTemplate.Labels[PvcTypeLabel] = pvcType
+
 	pvc, err := k.Clientset.CoreV1().PersistentVolumeClaims(pvcTemplate.Namespace).Create(context.TODO(), &pvcTemplate, metav1.CreateOptions{})
 	if err != nil {
 		if k8sErrors.IsAlreadyExists(err) {
 			log.Infof("[Touched] %v", err)
-			return nil
+			return k.ExpandPvc(pvcTemplate.Namespace, pvcTemplate.Name, *pvcTemplate.Spec.Resources.Requests.Storage())
 		}
 		return err
 	}
@@ -599,6 +1025,70 @@ func (k *KubernetesCluster) CreatePvc(pvcTemplate v1.PersistentVolumeClaim) erro
 	return nil
 }
 
+// ExpandPvc grows the pvc to the capacity if its storage class allows volume expansion,
+// and waits until the resize completed. A pvc already large enough is left untouched.
+func (k *KubernetesCluster) ExpandPvc(namespace string, pvcName string, capacity resource.Quantity) error {
+	ctx := context.TODO()
+	pvc, err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	current := pvc.Spec.Resources.Requests[v1.ResourceStorage]
+	if capacity.Cmp(current) <= 0 {
+		return nil
+	}
+
+	// Only the bound pvcs can be resized, the api rejects the others as invalid
+	if pvc.Status.Phase != v1.ClaimBound {
+		return fmt.Errorf("pvc %s from %s to %s: pvc is %s, not bound: %w", pvcName, current.String(), capacity.String(), pvc.Status.Phase, ErrCannotExpand)
+	}
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return fmt.Errorf("pvc %s from %s to %s: no storage class: %w", pvcName, current.String(), capacity.String(), ErrCannotExpand)
+	}
+	sc, err := k.Clientset.StorageV1().StorageClasses().Get(ctx, *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if sc.AllowVolumeExpansion == nil || !*sc.AllowVolumeExpansion {
+		return fmt.Errorf("pvc %s from %s to %s: storage class %s does not allow volume expansion: %w", pvcName, current.String(), capacity.String(), sc.Name, ErrCannotExpand)
+	}
+
+	log.Infof("[Expand] pvc: %s from %s to %s", pvcName, current.String(), capacity.String())
+	pvc.Spec.Resources.Requests[v1.ResourceStorage] = capacity
+	_, err = k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Update(ctx, pvc, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	return k.waitPvcResized(namespace, pvcName, capacity)
+}
+
+func (k *KubernetesCluster) waitPvcResized(namespace string, pvcName string, capacity resource.Quantity) error {
+	ctx := context.TODO()
+	err := wait.PollImmediate(5*time.Second, 5*time.Minute, func() (bool, error) {
+		pvc, err := k.Clientset.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if size, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok && size.Cmp(capacity) >= 0 {
+			log.Infof("[Expanded] pvc: %s capacity: %s", pvcName, size.String())
+			return true, nil
+		}
+		for _, condition := range pvc.Status.Conditions {
+			if condition.Type == v1.PersistentVolumeClaimFileSystemResizePending && condition.Status == v1.ConditionTrue {
+				// The file system is resized when the pvc is mounted by the rsync-server pod
+				log.Infof("[Expanded] pvc: %s file system resize pending", pvcName)
+				return true, nil
+			}
+		}
+		log.Debugf("[Wait] pvc: %s resizing", pvcName)
+		return false, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return fmt.Errorf("pvc %s to %s: %w", pvcName, capacity.String(), ErrPvcResizeTimeout)
+	}
+	return err
+}
+
 func (k *KubernetesCluster) CreateUserPvc(namespace string, name string, capacityString string) error {
 	var pvcTemplate v1.PersistentVolumeClaim
 	err := yaml.Unmarshal(UserPvcTemplate, &pvcTemplate)
@@ -616,7 +1106,7 @@ func (k *KubernetesCluster) CreateUserPvc(namespace string, name string, capacit
 	pvcTemplate.Namespace = namespace
 	pvcTemplate.Spec.Resources.Requests["storage"] = capacity
 
-	return k.CreatePvc(pvcTemplate)
+	return k.CreatePvc("user", pvcTemplate)
 }
 
 func (k *KubernetesCluster) CreateProjectPvc(namespace string, name string, capacityString string) error {
@@ -634,7 +1124,7 @@ func (k *KubernetesCluster) CreateProjectPvc(namespace string, name string, capa
 	pvcTemplate.Namespace = namespace
 	pvcTemplate.Spec.Resources.Requests["storage"] = capacity
 
-	return k.CreatePvc(pvcTemplate)
+	return k.CreatePvc("project", pvcTemplate)
 }
 
 func (k *KubernetesCluster) CreateRawPvc(namespace string, name string, capacityString string, accessMode v1.PersistentVolumeAccessMode) error {
@@ -648,7 +1138,7 @@ func (k *KubernetesCluster) CreateRawPvc(namespace string, name string, capacity
 	pvcTemplate.Spec.Resources.Requests = v1.ResourceList{"storage": capacity}
 	pvcTemplate.Spec.AccessModes = []v1.PersistentVolumeAccessMode{accessMode}
 
-	return k.CreatePvc(pvcTemplate)
+	return k.CreatePvc("raw", pvcTemplate)
 }
 
 func (k *KubernetesCluster) CreateDatasetPvc(namespace string, name string, capacityString string) error {
@@ -666,5 +1156,5 @@ func (k *KubernetesCluster) CreateDatasetPvc(namespace string, name string, capa
 	pvcTemplate.Namespace = namespace
 	pvcTemplate.Spec.Resources.Requests["storage"] = capacity
 
-	return k.CreatePvc(pvcTemplate)
+	return k.CreatePvc("dataset", pvcTemplate)
 }
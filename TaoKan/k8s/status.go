@@ -0,0 +1,149 @@
+package KubernetesAPI
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"path"
+	"sigs.k8s.io/yaml"
+	"strings"
+	"text/tabwriter"
+)
+
+// PvcStatusTypes are the pvc types listed by the status, in the listed order
+var PvcStatusTypes = []string{"user", "dataset", "project"}
+
+// PvcStatusFormats are the output formats of the status
+var PvcStatusFormats = []string{"table", "json", "yaml"}
+
+// PvcStatus is the status record of a pvc
+type PvcStatus struct {
+	Type         string   `json:"type,omitempty"`
+	Name         string   `json:"name"`
+	Capacity     string   `json:"capacity,omitempty"`
+	Phase        string   `json:"phase,omitempty"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	UsedBy       []string `json:"usedBy,omitempty"`
+}
+
+// PvcStatusFilter selects the pvcs of the status, the empty fields match any pvc
+type PvcStatusFilter struct {
+	Types     []string
+	Name      string
+	InUseOnly bool
+}
+
+// Match reports whether the filter selects the pvc
+func (f PvcStatusFilter) Match(status PvcStatus) bool {
+	if len(f.Types) > 0 {
+		matched := false
+		for _, pvcType := range f.Types {
+			matched = matched || pvcType == status.Type
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.Name != "" {
+		if matched, _ := path.Match(f.Name, status.Name); !matched {
+			return false
+		}
+	}
+	return !f.InUseOnly || len(status.UsedBy) > 0
+}
+
+// pvcStatusType returns the pvc type by the prefixes listed by ListUserPvc, ListDatasetPvc and ListProjectPvc
+func pvcStatusType(pvc v1.PersistentVolumeClaim) string {
+	switch {
+	case strings.HasPrefix(pvc.Name, "claim-"):
+		return "user"
+	case strings.HasPrefix(pvc.Name, "dataset-"):
+		return "dataset"
+	case strings.HasPrefix(pvc.Name, "project-"):
+		return "project"
+	}
+	return ""
+}
+
+// ListPvcStatus lists the status of the user, dataset and project pvcs selected by the filter
+func (k *KubernetesCluster) ListPvcStatus(namespace string, filter PvcStatusFilter) ([]PvcStatus, error) {
+	pvcs, err := k.ListPvc(namespace)
+	if err != nil {
+		return nil, err
+	}
+	pods, err := k.ListPods(namespace)
+	if err != nil {
+		return nil, err
+	}
+	usedBy := map[string][]string{}
+	for _, pod := range pods {
+		for _, volume := range pod.Spec.Volumes {
+			if volume.PersistentVolumeClaim != nil {
+				usedBy[volume.PersistentVolumeClaim.ClaimName] = append(usedBy[volume.PersistentVolumeClaim.ClaimName], pod.Name)
+			}
+		}
+	}
+
+	records := make([]PvcStatus, 0)
+	for _, pvcType := range PvcStatusTypes {
+		for _, pvc := range pvcs {
+			if pvcStatusType(pvc) != pvcType {
+				continue
+			}
+			status := PvcStatus{
+				Type:     pvcType,
+				Name:     pvc.Name,
+				Capacity: pvc.Spec.Resources.Requests.Storage().String(),
+				Phase:    string(pvc.Status.Phase),
+				UsedBy:   usedBy[pvc.Name],
+			}
+			if capacity, ok := pvc.Status.Capacity[v1.ResourceStorage]; ok {
+				status.Capacity = capacity.String()
+			}
+			if pvc.Spec.StorageClassName != nil {
+				status.StorageClass = *pvc.Spec.StorageClassName
+			}
+			if filter.Match(status) {
+				records = append(records, status)
+			}
+		}
+	}
+	return records, nil
+}
+
+// RenderPvcStatus writes the status records in the format of table, json or yaml
+func RenderPvcStatus(w io.Writer, records []PvcStatus, format string) error {
+	switch format {
+	case "", "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tNAME\tCAPACITY\tPHASE\tSTORAGECLASS\tUSED BY")
+		for _, record := range records {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", record.Type, record.Name,
+				orNone(record.Capacity), orNone(record.Phase), orNone(record.StorageClass), orNone(strings.Join(record.UsedBy, ",")))
+		}
+		return tw.Flush()
+	case "json":
+		data, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case "yaml":
+		data, err := yaml.Marshal(records)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("unsupported output format '%s', should be one of %s", format, strings.Join(PvcStatusFormats, ", "))
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
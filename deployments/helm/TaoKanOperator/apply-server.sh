@@ -1,3 +1,9 @@
 #! /bin/bash
 
-helm upgrade --install taokan-operator . --create-namespace --namespace hub --set taoKan.serverMode=true
+if [[ $1 == "" ]]; then
+  echo "Usage: `basename $0` <authorized_keys-file>"
+  exit 1
+fi
+
+helm upgrade --install taokan-operator . --create-namespace --namespace hub --set taoKan.serverMode=true \
+  --set-file taoKan.authorizedKeys=$1